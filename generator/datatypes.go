@@ -0,0 +1,124 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// allDatatypes is the -object value that generates every readable datatype
+const allDatatypes string = "all"
+
+// Datatypes is the catalog of OpenAir XML API datatypes that can be read.
+// Which of them an account can read depends on its modules and permissions.
+var Datatypes = []string{
+	"Agreement",
+	"Booking",
+	"BookingType",
+	"Category",
+	"Contact",
+	"CostCenter",
+	"Currency",
+	"CustomField",
+	"Customer",
+	"Department",
+	"Envelope",
+	"Invoice",
+	"Issue",
+	"Item",
+	"Jobcode",
+	"Payment",
+	"Payrolltype",
+	"Product",
+	"Project",
+	"ProjectAssign",
+	"ProjectBillingRule",
+	"ProjectGroup",
+	"ProjectStage",
+	"ProjectTask",
+	"ProjectTaskAssign",
+	"Proposal",
+	"PurchaseOrder",
+	"Purchaser",
+	"Reimbursement",
+	"RevenueRecognitionRule",
+	"Schedulerequest",
+	"Slip",
+	"Task",
+	"TaskTimecard",
+	"Ticket",
+	"Timecard",
+	"Timesheet",
+	"Timetype",
+	"Uprate",
+	"User",
+	"UserWorkschedule",
+	"Vendor",
+	"Workspace",
+}
+
+// isKnownDatatype reports whether datatype is in the catalog
+func isKnownDatatype(datatype string) bool {
+	for _, d := range Datatypes {
+		if d == datatype {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeStatus reports whether a datatype can be read. Err is nil when it can.
+type TypeStatus struct {
+	Datatype string
+	Err      error
+}
+
+// ListTypes reads a sample of every datatype in the catalog and reports
+// which of them can be read, from OpenAir or from the schema directory
+func ListTypes(ctx context.Context, c Config, o Options) ([]TypeStatus, error) {
+	return newGenerator(c, o).probe(ctx)
+}
+
+// probe reads a sample of every datatype in the catalog, concurrently. It
+// only fails when ctx is done.
+func (g *generator) probe(ctx context.Context) ([]TypeStatus, error) {
+	g.fetch(ctx, Datatypes)
+	statuses := make([]TypeStatus, len(Datatypes))
+	for i, datatype := range Datatypes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		statuses[i].Datatype = datatype
+		_, statuses[i].Err = g.readFields(ctx, datatype)
+	}
+	return statuses, nil
+}
+
+// datatypes returns the sorted datatypes to generate. For -object=all those
+// are the datatypes in the catalog that can be read.
+func (g *generator) datatypes(ctx context.Context) ([]string, error) {
+	if g.names != nil {
+		return g.names, nil
+	}
+	if g.objectNames == allDatatypes {
+		statuses, err := g.probe(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range statuses {
+			if s.Err == nil {
+				g.names = append(g.names, s.Datatype)
+			}
+		}
+		if len(g.names) == 0 {
+			return nil, errors.New("none of the datatypes can be read")
+		}
+	} else {
+		g.names = strings.Split(g.objectNames, ",")
+	}
+	sort.Slice(g.names, func(i, j int) bool {
+		return strings.Compare(g.names[i], g.names[j]) == -1
+	})
+	return g.names, nil
+}
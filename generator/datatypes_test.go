@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Datatypes", func() {
+	It("is sorted", func() {
+		Ω(sort.StringsAreSorted(Datatypes)).Should(BeTrue())
+	})
+
+	Describe("ListTypes()", func() {
+		It("reports which datatypes can be read", func() {
+			statuses, err := ListTypes(context.Background(), Config{}, Options{SchemaDir: "testdata/schema"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(statuses).Should(HaveLen(len(Datatypes)))
+
+			var readable []string
+			for _, s := range statuses {
+				if s.Err == nil {
+					readable = append(readable, s.Datatype)
+				}
+			}
+			Ω(readable).Should(Equal([]string{"CustomField", "Customer", "Project", "Timesheet"}))
+		})
+	})
+
+	Describe("GenerateModelFiles()", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "definition.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("generates every readable datatype for -object=all", func() {
+			g, err := New(Config{}, Options{
+				ObjectNames:  "all",
+				Dir:          dir,
+				OutputSuffix: "_openair",
+				SchemaDir:    "testdata/schema",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(ConsistOf(
+				filepath.Join(dir, "definition.go"),
+				filepath.Join(dir, "customfield_openair.go"),
+				filepath.Join(dir, "customer_openair.go"),
+				filepath.Join(dir, "project_openair.go"),
+				filepath.Join(dir, "timesheet_openair.go"),
+			))
+		})
+	})
+})
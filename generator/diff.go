@@ -0,0 +1,390 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// generatedHeader starts every generated file
+const generatedHeader = "// Code generated by openair; DO NOT EDIT."
+
+// maxDiffCells bounds the work spent matching lines; larger changes are
+// shown as every old line removed and every new line added
+const maxDiffCells = 1 << 24
+
+// FileChange is a generated file that differs from the file on disk
+type FileChange struct {
+	Name string
+
+	// New is set when there is no file on disk yet
+	New bool
+
+	// Removed is set when the file on disk is no longer generated
+	Removed bool
+
+	// Diff is a unified diff from the file on disk to the generated file
+	Diff string
+
+	// Fields are the changes to the fields of each datatype in the file
+	Fields []FieldChange
+}
+
+// FieldChange is a field of a datatype that was added, removed or retyped
+type FieldChange struct {
+	Type    string
+	Field   string
+	OldType string
+	NewType string
+}
+
+func (c FieldChange) String() string {
+	switch {
+	case c.OldType == "":
+		return fmt.Sprintf("%s: added %s %s", c.Type, c.Field, c.NewType)
+	case c.NewType == "":
+		return fmt.Sprintf("%s: removed %s %s", c.Type, c.Field, c.OldType)
+	}
+	return fmt.Sprintf("%s: retyped %s from %s to %s", c.Type, c.Field, c.OldType, c.NewType)
+}
+
+// Changes renders every file and returns those that differ from the files
+// in the generator's directory, along with the generated files there that
+// are no longer generated, sorted by name, without writing anything
+func (g *generator) Changes(ctx context.Context) ([]FileChange, error) {
+	files, err := g.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []FileChange
+	for _, name := range names {
+		old, err := ioutil.ReadFile(filepath.Join(g.dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && bytes.Equal(old, files[name]) {
+			continue
+		}
+		changes = append(changes, FileChange{
+			Name:   name,
+			New:    err != nil,
+			Diff:   unifiedDiff(name, old, files[name]),
+			Fields: fieldChanges(old, files[name]),
+		})
+	}
+
+	stale, err := g.staleFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stale {
+		old, err := ioutil.ReadFile(filepath.Join(g.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, FileChange{
+			Name:    name,
+			Removed: true,
+			Diff:    unifiedDiff(name, old, nil),
+			Fields:  fieldChanges(old, nil),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// staleFiles returns the generated files in the generator's directory with
+// the output prefix and suffix that aren't in files, sorted by name, such as
+// the file of a datatype that is no longer generated
+func (g *generator) staleFiles(files map[string][]byte) ([]string, error) {
+	infos, err := ioutil.ReadDir(g.dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.ToLower(g.outputPrefix)
+	suffix := strings.ToLower(g.outputSuffix)
+	var stale []string
+	for _, info := range infos {
+		name := info.Name()
+		if _, ok := files[name]; ok || info.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !strings.HasSuffix(name, suffix+".go") && !strings.HasSuffix(name, suffix+"_test.go") {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(g.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(src), []byte(generatedHeader)) {
+			stale = append(stale, name)
+		}
+	}
+	return stale, nil
+}
+
+// fieldChanges compares the datatype fields in two versions of a model file.
+// A version that isn't valid Go is taken to have no fields.
+func fieldChanges(old, new []byte) []FieldChange {
+	before, _ := modelFields(old)
+	after, _ := modelFields(new)
+
+	var changes []FieldChange
+	for typ, fields := range after {
+		for name, t := range fields {
+			if previous, ok := before[typ][name]; !ok {
+				changes = append(changes, FieldChange{Type: typ, Field: name, NewType: t})
+			} else if previous != t {
+				changes = append(changes, FieldChange{Type: typ, Field: name, OldType: previous, NewType: t})
+			}
+		}
+	}
+	for typ, fields := range before {
+		for name, t := range fields {
+			if _, ok := after[typ][name]; !ok {
+				changes = append(changes, FieldChange{Type: typ, Field: name, OldType: t})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// modelFields returns the Go type of each field of each datatype struct in
+// src, keyed by struct name and then raw field name. Datatype structs are
+// those with a <Name>Fields var, and include their embedded custom fields.
+func modelFields(src []byte) (map[string]map[string]string, error) {
+	models := map[string]map[string]string{}
+	if len(src) == 0 {
+		return models, nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	structs := map[string]*ast.StructType{}
+	vars := map[string]bool{}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					structs[spec.Name.Name] = st
+				}
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					vars[name.Name] = true
+				}
+			}
+		}
+	}
+
+	for name, st := range structs {
+		if vars[name+"Fields"] {
+			models[name] = map[string]string{}
+			addModelFields(models[name], st, structs)
+		}
+	}
+	return models, nil
+}
+
+func addModelFields(fields map[string]string, st *ast.StructType, structs map[string]*ast.StructType) {
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			if id, ok := f.Type.(*ast.Ident); ok && structs[id.Name] != nil {
+				addModelFields(fields, structs[id.Name], structs)
+			}
+			continue
+		}
+		if f.Tag == nil {
+			continue
+		}
+		tag, err := strconv.Unquote(f.Tag.Value)
+		if err != nil {
+			continue
+		}
+		path := strings.Split(reflect.StructTag(tag).Get("xml"), ",")[0]
+		if name := strings.Split(path, ">")[0]; name != "" && name != "-" {
+			fields[name] = types.ExprString(f.Type)
+		}
+	}
+}
+
+// diffLine is a line of a diff: kept (' '), removed ('-') or added ('+')
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the changes from old to new in unified diff format
+func unifiedDiff(name string, old, new []byte) string {
+	lines := diffLines(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", name, name)
+	// Line numbers before each line of the diff, in old and new
+	oldAt := make([]int, len(lines)+1)
+	newAt := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldAt[i+1], newAt[i+1] = oldAt[i], newAt[i]
+		if l.op != '+' {
+			oldAt[i+1]++
+		}
+		if l.op != '-' {
+			newAt[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is close enough to share
+		// context with this one
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines) && j <= end+2*diffContext; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		stop := end + diffContext + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(oldAt[start], oldAt[stop]-oldAt[start]),
+			hunkRange(newAt[start], newAt[stop]-newAt[start]))
+		for _, l := range lines[start:stop] {
+			fmt.Fprintf(&buf, "%c%s\n", l.op, l.text)
+		}
+		i = stop
+	}
+	return buf.String()
+}
+
+// hunkRange formats the lines of a hunk starting after line at
+func hunkRange(at, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", at)
+	}
+	if count == 1 {
+		return strconv.Itoa(at + 1)
+	}
+	return fmt.Sprintf("%d,%d", at+1, count)
+}
+
+func splitLines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+}
+
+// diffLines matches the lines of a and b, keeping the longest common
+// subsequence and removing or adding the rest
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var middle []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			middle = append(middle, diffLine{'-', l})
+		}
+		for _, l := range b {
+			middle = append(middle, diffLine{'+', l})
+		}
+	} else {
+		middle = lcsLines(a, b)
+	}
+	return append(append(prefix, middle...), suffix...)
+}
+
+func lcsLines(a, b []string) []diffLine {
+	// common[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	common := make([][]int32, len(a)+1)
+	for i := range common {
+		common[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
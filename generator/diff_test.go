@@ -0,0 +1,163 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diff", func() {
+	Describe("unifiedDiff()", func() {
+		It("shows changes with context", func() {
+			old := "a\nb\nc\nd\ne\nf\ng\nh\n"
+			new := "a\nb\nc\nD\ne\nf\ng\nh\n"
+			Ω(unifiedDiff("x.go", []byte(old), []byte(new))).Should(Equal(strings.Join([]string{
+				"--- a/x.go",
+				"+++ b/x.go",
+				"@@ -1,7 +1,7 @@",
+				" a",
+				" b",
+				" c",
+				"-d",
+				"+D",
+				" e",
+				" f",
+				" g",
+				"",
+			}, "\n")))
+		})
+
+		It("splits changes that are far apart into hunks", func() {
+			old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+			new := "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n"
+			Ω(unifiedDiff("x.go", []byte(old), []byte(new))).Should(Equal(strings.Join([]string{
+				"--- a/x.go",
+				"+++ b/x.go",
+				"@@ -1,3 +1,4 @@",
+				"+0",
+				" 1",
+				" 2",
+				" 3",
+				"@@ -9,4 +10,3 @@",
+				" 9",
+				" 10",
+				" 11",
+				"-12",
+				"",
+			}, "\n")))
+		})
+
+		It("adds every line of a new file", func() {
+			Ω(unifiedDiff("x.go", nil, []byte("a\nb\n"))).Should(Equal("--- a/x.go\n+++ b/x.go\n@@ -0,0 +1,2 @@\n+a\n+b\n"))
+		})
+	})
+
+	Describe("fieldChanges()", func() {
+		It("reports added, removed and retyped fields", func() {
+			old := "package openair\n" +
+				"type Project struct {\n" +
+				"\tActive string `xml:\"active,omitempty\"`\n" +
+				"\tNotes string `xml:\"notes,omitempty\"`\n" +
+				"}\n" +
+				"var ProjectFields = struct{}{}\n"
+			new := "package openair\n" +
+				"type Project struct {\n" +
+				"\tProjectCustom\n" +
+				"\tActive bool `xml:\"active,omitempty\"`\n" +
+				"}\n" +
+				"type ProjectCustom struct {\n" +
+				"\tRegion string `xml:\"region__c,omitempty\"`\n" +
+				"}\n" +
+				"type ProjectRead struct {\n" +
+				"\tStatus string `xml:\"status,attr\"`\n" +
+				"}\n" +
+				"var ProjectFields = struct{}{}\n"
+			Ω(fieldChanges([]byte(old), []byte(new))).Should(Equal([]FieldChange{
+				{Type: "Project", Field: "active", OldType: "string", NewType: "bool"},
+				{Type: "Project", Field: "notes", OldType: "string"},
+				{Type: "Project", Field: "region__c", NewType: "string"},
+			}))
+		})
+	})
+
+	Describe("FieldChange", func() {
+		It("describes the change", func() {
+			Ω(FieldChange{Type: "Project", Field: "active", NewType: "bool"}.String()).Should(Equal("Project: added active bool"))
+			Ω(FieldChange{Type: "Project", Field: "active", OldType: "string"}.String()).Should(Equal("Project: removed active string"))
+			Ω(FieldChange{Type: "Project", Field: "active", OldType: "string", NewType: "bool"}.String()).Should(Equal("Project: retyped active from string to bool"))
+		})
+	})
+
+	Describe("Changes()", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "definition.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("reports stale files without writing them", func() {
+			o := Options{ObjectNames: "Project", Dir: dir, OutputSuffix: "_openair", SchemaDir: "testdata/schema"}
+			g, err := New(Config{}, o)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			changes, err := g.Changes(context.Background())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(3))
+			Ω(changes[2].Name).Should(Equal("project_openair.go"))
+			Ω(changes[2].New).Should(BeTrue())
+			Ω(changes[2].Fields).Should(ContainElement(FieldChange{Type: "Project", Field: "budget", NewType: "string"}))
+			Ω(filepath.Join(dir, "project_openair.go")).ShouldNot(BeAnExistingFile())
+
+			Ω(g.GenerateCommonFile(context.Background())).Should(Succeed())
+			Ω(g.GenerateCommonTestFile(context.Background())).Should(Succeed())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+			changes, err = g.Changes(context.Background())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(BeEmpty())
+
+			o.Typed = true
+			g, err = New(Config{}, o)
+			Ω(err).ShouldNot(HaveOccurred())
+			changes, err = g.Changes(context.Background())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(1))
+			Ω(changes[0].New).Should(BeFalse())
+			Ω(changes[0].Diff).Should(ContainSubstring("+\tBudget       float64"))
+			Ω(changes[0].Fields).Should(ContainElement(FieldChange{Type: "Project", Field: "budget", OldType: "string", NewType: "float64"}))
+		})
+
+		It("reports generated files that are no longer generated", func() {
+			o := Options{ObjectNames: "Project,Customer", Dir: dir, OutputSuffix: "_openair", SchemaDir: "testdata/schema"}
+			g, err := New(Config{}, o)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(Succeed())
+			err = ioutil.WriteFile(filepath.Join(dir, "helpers_openair.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			o.ObjectNames = "Project"
+			g, err = New(Config{}, o)
+			Ω(err).ShouldNot(HaveOccurred())
+			changes, err := g.Changes(context.Background())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(2))
+			Ω(changes[0].Name).Should(Equal("common_openair.go"))
+			Ω(changes[1].Name).Should(Equal("customer_openair.go"))
+			Ω(changes[1].Removed).Should(BeTrue())
+			Ω(changes[1].Diff).Should(ContainSubstring("-type Customer struct {"))
+			Ω(changes[1].Fields).Should(ContainElement(FieldChange{Type: "Customer", Field: "name", OldType: "string"}))
+		})
+	})
+})
@@ -2,6 +2,8 @@ package generator
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"go/ast"
@@ -12,14 +14,15 @@ import (
 	"go/token"
 	"go/types"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/kelseyhightower/envconfig"
+	"sync"
+	"text/template"
 )
 
 // Date is a date
@@ -30,6 +33,23 @@ const deleted string = "deleted"
 // Address is an address
 const Address string = "Address"
 
+// Time is a Date decoded as a time.Time
+const Time string = "Time"
+
+// customSuffix ends the raw name of every custom field
+const customSuffix string = "__c"
+
+// customFieldType is the datatype that describes custom fields
+const customFieldType string = "CustomField"
+
+// customFieldLimit is how many custom field definitions are read at once
+const customFieldLimit int = 1000
+
+var (
+	integerValue = regexp.MustCompile(`^-?[0-9]+$`)
+	decimalValue = regexp.MustCompile(`^-?[0-9]+\.[0-9]+$`)
+)
+
 // Config is OpenAir configuration
 type Config struct {
 	Scheme    string `default:"https"`
@@ -48,23 +68,223 @@ type generator struct {
 	pkg          string
 	outputPrefix string
 	outputSuffix string
+	schemaDir    string
+	capture      bool
+	typed        bool
+	overrides    map[string]Override
+	customFields bool
+	definitions  map[string]map[string]string
+	names        []string
+	concurrency  int
+	samples      int
+	offline      bool
+
+	mu      sync.Mutex
+	schemas map[string]schemaResult
+}
+
+// schemaResult is the outcome of reading the schema of a datatype
+type schemaResult struct {
+	body []byte
+	err  error
+}
+
+// defaultConcurrency is how many schemas are read at once by default
+const defaultConcurrency = 4
+
+// defaultSamples is how many records of each datatype are read by default
+const defaultSamples = 10
+
+// Errors holds the errors of every datatype that failed, in order
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
 }
 
 // OpenAirGenerator generates an API client for the OpenAir XML API
 type OpenAirGenerator interface {
-	GenerateCommonFile()
-	GenerateCommonTestFile()
-	GenerateModelFiles()
+	// GenerateFiles writes every generated file and removes the generated
+	// files that are no longer generated, and does neither when any
+	// datatype fails
+	GenerateFiles(ctx context.Context) error
+
+	GenerateCommonFile(ctx context.Context) error
+	GenerateCommonTestFile(ctx context.Context) error
+
+	// GenerateModelFiles writes the file of each datatype, and the file of
+	// their nested types or, when they have none, removes a generated one
+	GenerateModelFiles(ctx context.Context) error
+
+	// Changes reports the generated files that differ from those on disk,
+	// without writing them
+	Changes(ctx context.Context) ([]FileChange, error)
+}
+
+// Options controls which datatypes are generated, where their schemas are
+// read from and where the generated files are written
+type Options struct {
+	ObjectNames  string
+	Dir          string
+	OutputPrefix string
+	OutputSuffix string
+
+	// SchemaDir is a directory of captured OpenAir responses, one
+	// <Datatype>.xml file per datatype. When set, schemas are read from it
+	// instead of OpenAir.
+	SchemaDir string
+
+	// Capture fetches each datatype from OpenAir and saves the response to
+	// SchemaDir before generating from it.
+	Capture bool
+
+	// Typed infers int64, float64 and bool fields from the values of all
+	// the sample records read for each datatype (see Samples), and decodes
+	// Date fields as Time, instead of generating strings. A field that the
+	// samples disagree on stays a string, as does a flag that the samples
+	// don't set to both 0 and 1.
+	Typed bool
+
+	// Overrides change how individual fields are generated, keyed by
+	// "Datatype.field" using the raw OpenAir names, e.g. "Project.budget".
+	Overrides map[string]Override
+
+	// CustomFields reads the CustomField datatype, or SchemaDir's
+	// CustomField.xml, to type custom fields by their definitions rather
+	// than their sample values.
+	CustomFields bool
+
+	// Concurrency is how many schemas are read from OpenAir at once. It
+	// defaults to 4.
+	Concurrency int
+
+	// Samples is how many records of each datatype are read from OpenAir to
+	// infer its fields. It defaults to 10.
+	Samples int
+}
+
+// Override changes how one field is generated. Empty values keep what the
+// generator would otherwise use.
+type Override struct {
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+	Tags string `json:"tags,omitempty"`
+	Skip bool   `json:"skip,omitempty"`
 }
 
-// New creates a generator
-func New(c Config, objectNames string, dir string, outputPrefix string, outputSuffix string) OpenAirGenerator {
-	g := &generator{c: c, objectNames: objectNames, dir: dir, outputPrefix: outputPrefix, outputSuffix: outputSuffix}
-	pkg, err := GetPackageName(dir, g.outputPrefix, g.outputSuffix+".go")
+// UnmarshalJSON accepts either an object or the string "skip"
+func (o *Override) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "skip" {
+			return fmt.Errorf("invalid override %q", s)
+		}
+		*o = Override{Skip: true}
+		return nil
+	}
+	type override Override
+	return json.Unmarshal(data, (*override)(o))
+}
+
+// LoadOverrides reads a JSON file of field overrides, e.g.
+//
+//	{
+//	  "Project.budget": {"type": "float64"},
+//	  "Customer.paid": {"name": "Paid"},
+//	  "Customer.notes": "skip"
+//	}
+func LoadOverrides(path string) (map[string]Override, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]Override
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return overrides, nil
+}
+
+// New creates a generator for the package in o.Dir
+func New(c Config, o Options) (OpenAirGenerator, error) {
+	g := newGenerator(c, o)
+	pkg, err := GetPackageName(g.dir, g.outputPrefix, g.outputSuffix+".go")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	g.pkg = pkg
+	return g, nil
+}
+
+// Generate renders the files for package pkg from schemas, the captured
+// OpenAir responses keyed by datatype, and returns their sources keyed by
+// file name. It neither reads nor writes files, nor contacts OpenAir, so
+// o.Dir, o.SchemaDir and o.Capture are ignored. Unless o.ObjectNames is
+// set, every datatype in schemas is generated.
+// Invalid Go is still returned, with the error.
+func Generate(ctx context.Context, pkg string, schemas map[string][]byte, o Options) (map[string][]byte, error) {
+	g := newGenerator(Config{}, o)
+	g.pkg = pkg
+	g.offline = true
+	for datatype, body := range schemas {
+		g.schemas[datatype] = schemaResult{body: body}
+	}
+	if g.objectNames == "" {
+		for datatype := range schemas {
+			g.names = append(g.names, datatype)
+		}
+		sort.Strings(g.names)
+	}
+
+	return g.files(ctx)
+}
+
+// files renders every generated file with render, keyed by file name
+func (g *generator) files(ctx context.Context) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	var invalid error
+	for _, render := range []func(context.Context) (map[string][]byte, error){g.commonFile, g.commonTestFile, g.modelFiles} {
+		rendered, err := render(ctx)
+		if rendered == nil {
+			return nil, err
+		}
+		for name, src := range rendered {
+			files[name] = src
+		}
+		if err != nil && invalid == nil {
+			invalid = err
+		}
+	}
+	return files, invalid
+}
+
+// newGenerator creates a generator that has not yet found its package
+func newGenerator(c Config, o Options) *generator {
+	g := &generator{
+		c:            c,
+		objectNames:  o.ObjectNames,
+		dir:          o.Dir,
+		outputPrefix: o.OutputPrefix,
+		outputSuffix: o.OutputSuffix,
+		schemaDir:    o.SchemaDir,
+		capture:      o.Capture,
+		typed:        o.Typed,
+		overrides:    o.Overrides,
+		customFields: o.CustomFields,
+		concurrency:  o.Concurrency,
+		samples:      o.Samples,
+		schemas:      map[string]schemaResult{},
+	}
+	if g.concurrency <= 0 {
+		g.concurrency = defaultConcurrency
+	}
+	if g.samples <= 0 {
+		g.samples = defaultSamples
+	}
 	return g
 }
 
@@ -72,19 +292,30 @@ type field struct {
 	FieldName string
 	RawName   string
 	FieldType string
-}
 
-func fetchFromOpenAir(datatype string) ([]byte, error) {
-	var c Config
-	err := envconfig.Process("openair", &c)
-	if err != nil {
-		return nil, err
-	}
+	// Custom is set for custom fields, which are generated in their own
+	// struct and named without the __c suffix
+	Custom bool
 
+	// Tag replaces the struct tag derived from RawName and FieldType
+	Tag string
+
+	// Comment says how sure the generator is of the field and its type
+	Comment string
+
+	// Path is the XML path of a nested element, e.g. flags>Flag, when it
+	// isn't just RawName
+	Path string
+
+	// Elements are the fields of a nested element's type
+	Elements []field
+}
+
+func fetchFromOpenAir(ctx context.Context, c Config, datatype string, limit int) ([]byte, error) {
 	url := fmt.Sprintf("%s://%s/api.pl", c.Scheme, c.Domain)
 	tmpl := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
   <request API_version="1.0" client_ver="1.1"
-  namespace="default" key="%s">
+  namespace="%s" key="%s">
     <Auth>
       <Login>
         <company>%s</company>
@@ -92,16 +323,16 @@ func fetchFromOpenAir(datatype string) ([]byte, error) {
         <password>%s</password>
       </Login>
     </Auth>
-    <Read type="%s" method="all" limit="1" enable_custom="1" include_nondeleted="1" deleted="1" />
+    <Read type="%s" method="all" limit="%d" enable_custom="1" include_nondeleted="1" deleted="1" />
   </request>`
 
-	payload := strings.NewReader(fmt.Sprintf(tmpl, c.Key, c.Company, c.User, c.Password, datatype))
+	payload := strings.NewReader(fmt.Sprintf(tmpl, c.Namespace, c.Key, c.Company, c.User, c.Password, datatype, limit))
 	req, err := http.NewRequest(http.MethodPost, url, payload)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("content-type", "application/xml")
-	res, err := http.DefaultClient.Do(req)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -109,41 +340,438 @@ func fetchFromOpenAir(datatype string) ([]byte, error) {
 	return ioutil.ReadAll(res.Body)
 }
 
-func buildFields(obj string) []field {
-	var r Response
-	var fields []field
-	body, err := fetchFromOpenAir(obj)
+// SchemaPath returns the path of the captured schema for datatype in dir
+func SchemaPath(dir string, datatype string) string {
+	return filepath.Join(dir, datatype+".xml")
+}
+
+// schema returns the OpenAir response describing datatype, either from the
+// schema directory or from OpenAir itself. Responses and errors are kept, so
+// each datatype is only read once.
+func (g *generator) schema(ctx context.Context, datatype string) ([]byte, error) {
+	g.mu.Lock()
+	r, ok := g.schemas[datatype]
+	g.mu.Unlock()
+	if ok {
+		return r.body, r.err
+	}
+	if g.offline {
+		return nil, fmt.Errorf("no schema for %s", datatype)
+	}
+
+	body, err := g.readSchema(ctx, datatype)
+	if ctx.Err() != nil {
+		// Reading again with another context may succeed
+		return nil, ctx.Err()
+	}
+	g.mu.Lock()
+	g.schemas[datatype] = schemaResult{body: body, err: err}
+	g.mu.Unlock()
+	return body, err
+}
+
+// fetch reads the schemas of datatypes concurrently, at most g.concurrency
+// at a time, so that later calls to schema return at once. Errors are kept
+// for those calls to return.
+func (g *generator) fetch(ctx context.Context, datatypes []string) {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < g.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for datatype := range work {
+				g.schema(ctx, datatype)
+			}
+		}()
+	}
+
+	seen := map[string]bool{}
+	for _, datatype := range datatypes {
+		if !seen[datatype] {
+			seen[datatype] = true
+			work <- datatype
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (g *generator) readSchema(ctx context.Context, datatype string) ([]byte, error) {
+	if g.schemaDir != "" && !g.capture {
+		return ioutil.ReadFile(SchemaPath(g.schemaDir, datatype))
+	}
+
+	limit := g.samples
+	if datatype == customFieldType {
+		limit = customFieldLimit
+	}
+	body, err := fetchFromOpenAir(ctx, g.c, datatype, limit)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	err = xml.Unmarshal(body, &r)
+	if !g.capture {
+		return body, nil
+	}
+
+	// Don't save a response that can't be generated from later
+	if _, err := parseFields(body, false); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(g.schemaDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(SchemaPath(g.schemaDir, datatype), body, 0644); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (g *generator) buildFields(ctx context.Context, datatype string) ([]field, error) {
+	fields, err := g.readFields(ctx, datatype)
 	if err != nil {
-		log.Fatal(err)
+		if !isKnownDatatype(datatype) {
+			return nil, fmt.Errorf("%s: %v (not a known datatype; run openair list-types to see which can be read)", datatype, err)
+		}
+		return nil, fmt.Errorf("%s: %v", datatype, err)
 	}
-	hasDeleted := false
-	for _, e := range r.Read.Entity.Element {
-		clean := cleanname(e.XMLName.Local)
-		t := "string"
-		if len(e.Element) > 0 && e.Element[0].XMLName.Local == Date {
-			t = Date
+	if g.customFields {
+		definitions, err := g.customFieldDefinitions(ctx)
+		if err != nil {
+			return nil, err
 		}
-		if len(e.Element) > 0 && e.Element[0].XMLName.Local == Address {
-			t = Address
+		applyDefinitions(fields, definitions[strings.ToLower(datatype)])
+	}
+	return applyOverrides(datatype, fields, g.overrides)
+}
+
+// readFields reads the schema of datatype and parses its fields
+func (g *generator) readFields(ctx context.Context, datatype string) ([]field, error) {
+	body, err := g.schema(ctx, datatype)
+	if err != nil {
+		return nil, err
+	}
+	return parseFields(body, g.typed)
+}
+
+// customFieldDefinitions returns the types of custom fields by datatype and
+// name, reading them the first time they are needed
+func (g *generator) customFieldDefinitions(ctx context.Context) (map[string]map[string]string, error) {
+	if g.definitions != nil {
+		return g.definitions, nil
+	}
+	body, err := g.schema(ctx, customFieldType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", customFieldType, err)
+	}
+	g.definitions, err = parseCustomFields(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", customFieldType, err)
+	}
+	return g.definitions, nil
+}
+
+// parseCustomFields reads CustomField records into the Go type of each
+// custom field, keyed by lower case datatype and then raw field name
+func parseCustomFields(body []byte) (map[string]map[string]string, error) {
+	var r struct {
+		Auth Auth `xml:"Auth"`
+		Read struct {
+			Status       string `xml:"status,attr"`
+			CustomFields []struct {
+				Name        string `xml:"name"`
+				Association string `xml:"association"`
+				Type        string `xml:"type"`
+			} `xml:"CustomField"`
+		} `xml:"Read"`
+	}
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	if r.Auth.Status != "0" {
+		return nil, fmt.Errorf("authentication failed with status %s", r.Auth.Status)
+	}
+	if r.Read.Status != "0" {
+		return nil, fmt.Errorf("read failed with status %s", r.Read.Status)
+	}
+
+	definitions := map[string]map[string]string{}
+	for _, d := range r.Read.CustomFields {
+		var t string
+		switch d.Type {
+		case "checkbox":
+			t = "bool"
+		case "numeric", "currency", "ratio":
+			t = "float64"
+		default:
+			t = "string"
 		}
-		if strings.ToLower(clean) == deleted {
-			hasDeleted = true
+		association := strings.ToLower(d.Association)
+		if definitions[association] == nil {
+			definitions[association] = map[string]string{}
 		}
-		fields = append(fields, field{FieldName: clean, RawName: e.XMLName.Local, FieldType: t})
+		definitions[association][d.Name+customSuffix] = t
+	}
+	return definitions, nil
+}
+
+// applyDefinitions types the custom fields that have a definition. Date
+// fields keep the type the samples gave them, since their definition doesn't
+// say how they are encoded.
+func applyDefinitions(fields []field, definitions map[string]string) {
+	for i := range fields {
+		t, ok := definitions[fields[i].RawName]
+		if !ok || isDate(fields[i].FieldType) {
+			continue
+		}
+		fields[i].FieldType = t
+		fields[i].Comment = "typed by its CustomField definition"
+	}
+}
+
+// fixedTypes are the types the generated methods can compare and set the id
+// and deleted fields as
+var fixedTypes = map[string][]string{
+	"id":    {"string", "int64"},
+	deleted: {"string", "bool", "int64"},
+}
+
+// applyOverrides changes fields of datatype as the overrides say. The
+// generated methods refer to id, updated and deleted by name, so those
+// can't be renamed, and deleted can't be skipped.
+func applyOverrides(datatype string, fields []field, overrides map[string]Override) ([]field, error) {
+	var result []field
+	used := map[string]bool{}
+	for _, f := range fields {
+		key := datatype + "." + f.RawName
+		o, ok := overrides[key]
+		if !ok {
+			result = append(result, f)
+			continue
+		}
+		used[key] = true
+		fixed := f.RawName == "id" || f.RawName == "updated" || f.RawName == deleted
+		if o.Skip && f.RawName == deleted {
+			return nil, fmt.Errorf("override %s: the deleted field can't be skipped", key)
+		}
+		if o.Name != "" && fixed {
+			return nil, fmt.Errorf("override %s: the %s field can't be renamed", key, f.RawName)
+		}
+		if allowed, ok := fixedTypes[f.RawName]; ok && o.Type != "" && !contains(allowed, o.Type) {
+			return nil, fmt.Errorf("override %s: the type of the %s field must be one of %s", key, f.RawName, strings.Join(allowed, ", "))
+		}
+		if o.Skip {
+			continue
+		}
+		if o.Type != "" {
+			f.FieldType = o.Type
+			f.Comment = "typed by override"
+			f.Path = ""
+			f.Elements = nil
+		}
+		if o.Name != "" {
+			f.FieldName = o.Name
+		}
+		f.Tag = o.Tags
+		result = append(result, f)
+	}
+
+	for key := range overrides {
+		if strings.HasPrefix(key, datatype+".") && !used[key] {
+			return nil, fmt.Errorf("override %s: %s has no such field", key, datatype)
+		}
+	}
+	type fieldName struct {
+		name   string
+		custom bool
+	}
+	names := map[fieldName]bool{}
+	for _, f := range result {
+		name := fieldName{f.FieldName, f.Custom}
+		if names[name] {
+			return nil, fmt.Errorf("%s: more than one field is named %s", datatype, f.FieldName)
+		}
+		names[name] = true
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Compare(result[i].FieldName, result[j].FieldName) == -1
+	})
+	return result, nil
+}
+
+// unusedOverrides returns an error, sorted by key, for each override of a
+// datatype that isn't one of datatypes, such as a misspelled one
+func unusedOverrides(datatypes []string, overrides map[string]Override) Errors {
+	var keys []string
+	for key := range overrides {
+		if !contains(datatypes, strings.SplitN(key, ".", 2)[0]) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var errs Errors
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("override %s: %s is not one of the datatypes being generated", key, strings.SplitN(key, ".", 2)[0]))
+	}
+	return errs
+}
+
+func parseFields(body []byte, typed bool) ([]field, error) {
+	var r Response
+	err := xml.Unmarshal(body, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Auth.Status != "0" {
+		return nil, fmt.Errorf("authentication failed with status %s", r.Auth.Status)
+	}
+	if r.Read.Status != "0" {
+		return nil, fmt.Errorf("read failed with status %s", r.Read.Status)
+	}
+
+	samples := make([][]Element, len(r.Read.Entities))
+	for i, e := range r.Read.Entities {
+		samples[i] = e.Element
 	}
+	fields := collectFields(samples, typed)
 
+	hasDeleted := false
+	for _, f := range fields {
+		if strings.ToLower(f.FieldName) == deleted {
+			hasDeleted = true
+		}
+	}
 	if !hasDeleted {
-		fields = append(fields, field{FieldName: "deleted", RawName: "deleted", FieldType: "string"})
+		fields = append(fields, field{FieldName: "Deleted", RawName: "deleted", FieldType: "string", Comment: sampleComment(0, 0)})
+	}
+	return nameFields(fields), nil
+}
+
+// collectFields merges the elements of every sample into fields. Elements
+// with child elements become nested types, whose fields are collected from
+// the children in turn.
+func collectFields(samples [][]Element, typed bool) []field {
+	var fields []field
+	index := map[string]int{}
+	set := map[string]int{}
+	values := map[string][]string{}
+	children := map[string][][]Element{}
+	for _, sample := range samples {
+		for _, e := range sample {
+			raw := e.XMLName.Local
+			i, ok := index[raw]
+			if !ok {
+				i = len(fields)
+				index[raw] = i
+				fields = append(fields, field{
+					FieldName: cleanname(strings.TrimSuffix(raw, customSuffix)),
+					RawName:   raw,
+					FieldType: "string",
+					Custom:    strings.HasSuffix(raw, customSuffix),
+				})
+			}
+			f := &fields[i]
+			if len(e.Element) == 0 {
+				if strings.TrimSpace(e.Value) != "" {
+					values[raw] = append(values[raw], e.Value)
+					set[raw]++
+				}
+				continue
+			}
+
+			// A compound element in any record decides the type
+			set[raw]++
+			child := e.Element[0].XMLName.Local
+			switch {
+			case child == Date || child == Address:
+				if f.FieldType == "string" {
+					f.FieldType = child
+				}
+			case isWrapper(e):
+				// OpenAir wraps records in an element named after the
+				// field, e.g. flags>Flag
+				if f.FieldType == "string" {
+					f.FieldType = nestedName(child)
+					f.Path = raw + ">" + child
+				}
+				if len(e.Element) > 1 || strings.ToLower(raw) == strings.ToLower(child)+"s" {
+					f.FieldType = "[]" + strings.TrimPrefix(f.FieldType, "[]")
+				}
+				for _, c := range e.Element {
+					children[raw] = append(children[raw], c.Element)
+				}
+			default:
+				if f.FieldType == "string" {
+					f.FieldType = nestedName(raw)
+				}
+				children[raw] = append(children[raw], e.Element)
+			}
+		}
+	}
+
+	for i := range fields {
+		f := &fields[i]
+		f.Comment = sampleComment(set[f.RawName], len(samples))
+		if c, ok := children[f.RawName]; ok {
+			f.Elements = nameFields(collectFields(c, typed))
+		}
+		if !typed {
+			continue
+		}
+		switch f.FieldType {
+		case Date:
+			f.FieldType = Time
+		case "string":
+			var consistent bool
+			f.FieldType, consistent = inferTypes(f.FieldName, values[f.RawName])
+			if !consistent {
+				f.Comment += "; values disagree, so string"
+			}
+		}
 	}
+	return fields
+}
 
+// isWrapper reports whether every child of e is a record of the same
+// datatype, such as each Flag in flags>Flag
+func isWrapper(e Element) bool {
+	name := e.Element[0].XMLName.Local
+	if strings.ToUpper(name[:1]) != name[:1] {
+		return false
+	}
+	for _, c := range e.Element {
+		if c.XMLName.Local != name {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedNames are the types of the common file, which nested types can't
+// be named after
+var reservedNames = map[string]bool{
+	"API": true, "Address": true, "Auth": true, "Batch": true, "Command": true,
+	"Config": true, "Date": true, "Error": true, "Field": true, "ListOption": true,
+	"Option": true, "Query": true, "RecordState": true, "Result": true,
+	"RetryPolicy": true, "Time": true, "Tombstone": true,
+}
+
+// nestedName returns the type name of the nested element raw
+func nestedName(raw string) string {
+	name := cleanname(raw)
+	if reservedNames[name] {
+		return name + "Element"
+	}
+	return name
+}
+
+// nameFields gives fields that clean to the same name a number, and sorts
+// them by name
+func nameFields(fields []field) []field {
 	for _, f := range fields {
 		count := 1
 		for i, f2 := range fields {
-			if f.FieldName == f2.FieldName && f.RawName != f2.RawName {
+			if f.FieldName == f2.FieldName && f.RawName != f2.RawName && f.Custom == f2.Custom {
 				f3 := &fields[i]
 				if strings.Contains(f3.RawName, "_") {
 					f3.FieldName = f3.FieldName + strconv.Itoa(count)
@@ -158,84 +786,365 @@ func buildFields(obj string) []field {
 	return fields
 }
 
-func (g *generator) GenerateModelFiles() {
-	datatypes := strings.Split(g.objectNames, ",")
-	sort.Slice(datatypes, func(i int, j int) bool {
-		return strings.Compare(datatypes[i], datatypes[j]) == -1
-	})
+// sampleComment describes how many of the samples set a field
+func sampleComment(set, total int) string {
+	switch {
+	case total == 0:
+		return "not in the samples"
+	case total == 1 && set == 0:
+		return "empty in the only sample"
+	case total == 1:
+		return "set in the only sample"
+	case set == 0:
+		return fmt.Sprintf("empty in all %d samples", total)
+	case set == total:
+		return fmt.Sprintf("set in all %d samples", total)
+	}
+	return fmt.Sprintf("set in %d of %d samples", set, total)
+}
+
+// typeRank orders the inferred types from most to least specific. Values of
+// a type can be read as any later type.
+var typeRank = map[string]int{"bool": 0, "int64": 1, "float64": 2, "string": 3}
+
+// inferTypes picks the most specific Go type that fits every sample value of
+// a field. It is not consistent when some values only fit as a string while
+// others would fit a more specific type.
+func inferTypes(name string, values []string) (string, bool) {
+	t := ""
+	specific := false
+	flags := map[string]bool{}
+	for _, v := range values {
+		vt := inferType(name, v)
+		if vt != "string" {
+			specific = true
+		}
+		if vt == "bool" {
+			flags[v] = true
+		}
+		if t == "" || typeRank[vt] > typeRank[t] {
+			t = vt
+		}
+	}
+	if t == "" {
+		return "string", true
+	}
+	// A field only ever seen as 0 or only as 1 may be a count or a code
+	// that later records set to 2, so it takes both to make a bool
+	if t == "bool" && len(flags) < 2 {
+		return "string", true
+	}
+	return t, t != "string" || !specific
+}
+
+// inferType picks the Go type of a field from its name and sample value.
+// Only ids become int64, so that codes with leading zeros stay strings.
+func inferType(name string, value string) string {
+	id := strings.HasSuffix(name, "ID")
+	switch {
+	case integerValue.MatchString(value) && id:
+		return "int64"
+	case value == "0" || value == "1":
+		return "bool"
+	case decimalValue.MatchString(value):
+		return "float64"
+	}
+	return "string"
+}
+
+// isDate reports whether t is a type used for OpenAir Date fields
+func isDate(t string) bool {
+	return t == Date || t == Time
+}
+
+// literal returns value as a Go literal of type t
+func literal(t string, value string) string {
+	switch t {
+	case "bool":
+		return strconv.FormatBool(value == "1")
+	case "int64", "float64":
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// zero returns the zero value of type t as a Go literal
+func zero(t string) string {
+	switch t {
+	case "bool":
+		return "false"
+	case "int64", "float64":
+		return "0"
+	}
+	return `""`
+}
+
+// hasField reports whether fields includes the field with the raw name
+func hasField(fields []field, rawName string) bool {
+	for _, f := range fields {
+		if f.RawName == rawName {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldType returns the type of the field with the raw name
+func fieldType(fields []field, rawName string) string {
+	for _, f := range fields {
+		if f.RawName == rawName {
+			return f.FieldType
+		}
+	}
+	return ""
+}
+
+// hasDateField reports whether fields includes a Date or Time field with the
+// raw name
+func hasDateField(fields []field, rawName string) bool {
+	for _, f := range fields {
+		if f.RawName == rawName && isDate(f.FieldType) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) GenerateFiles(ctx context.Context) error {
+	files, err := g.files(ctx)
+	if files == nil {
+		return err
+	}
+	stale, staleErr := g.staleFiles(files)
+	if staleErr != nil {
+		return staleErr
+	}
+	if err := g.remove(stale); err != nil {
+		return err
+	}
+	return g.write(files, err)
+}
+
+func (g *generator) GenerateModelFiles(ctx context.Context) error {
+	files, err := g.modelFiles(ctx)
+	if files == nil {
+		return err
+	}
+	// Only the types file can go stale here; the others aren't rendered
+	stale, staleErr := g.staleFiles(files)
+	if staleErr != nil {
+		return staleErr
+	}
+	for _, name := range stale {
+		if name == g.typesFile() {
+			if err := g.remove([]string{name}); err != nil {
+				return err
+			}
+		}
+	}
+	return g.write(files, err)
+}
+
+func (g *generator) GenerateCommonFile(ctx context.Context) error {
+	return g.write(g.commonFile(ctx))
+}
+
+func (g *generator) GenerateCommonTestFile(ctx context.Context) error {
+	return g.write(g.commonTestFile(ctx))
+}
+
+// modelFiles renders a file for each datatype with render, keyed by file name
+func (g *generator) modelFiles(ctx context.Context) (map[string][]byte, error) {
+	datatypes, err := g.datatypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if g.customFields {
+		g.fetch(ctx, append([]string{customFieldType}, datatypes...))
+		if _, err := g.customFieldDefinitions(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		g.fetch(ctx, datatypes)
+	}
+
+	files := map[string][]byte{}
+	var invalid error
+	var errs Errors
+	nested := map[string]*nestedType{}
 	for _, datatype := range datatypes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		name := cleanname(datatype)
-		fields := buildFields(datatype)
+		fields, err := g.buildFields(ctx, datatype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addNestedTypes(nested, datatype, fields)
+		var standard, custom []field
+		for _, f := range fields {
+			if f.Custom {
+				custom = append(custom, f)
+			} else {
+				standard = append(standard, f)
+			}
+		}
 		var context = struct {
 			PackageName string
 			TypeName    string
 			RawTypeName string
 			Fields      []field
+			Custom      []field
+			HasID       bool
+			HasUpdated  bool
+			IDType      string
+			Deleted     string
 		}{
 			PackageName: g.pkg,
 			TypeName:    name,
 			RawTypeName: datatype,
-			Fields:      fields,
+			Fields:      standard,
+			Custom:      custom,
+			HasID:       hasField(fields, "id"),
+			HasUpdated:  hasDateField(fields, "updated"),
+			IDType:      fieldType(fields, "id"),
+			Deleted:     literal(fieldType(fields, deleted), "1"),
 		}
 
-		var buf bytes.Buffer
-		if err := generatedTmpl.Execute(&buf, context); err != nil {
-			log.Fatalf("generating code: %v", err)
+		output := strings.ToLower(g.outputPrefix + context.TypeName + g.outputSuffix + ".go")
+		src, err := render(generatedTmpl, context)
+		if src == nil {
+			return nil, fmt.Errorf("%s: %v", output, err)
+		}
+		files[output] = src
+		if err != nil && invalid == nil {
+			invalid = fmt.Errorf("%s: %v", output, err)
 		}
+	}
+	errs = append(errs, unusedOverrides(datatypes, g.overrides)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
-		src, err := format.Source(buf.Bytes())
-		if err != nil {
-			log.Printf("warning: internal error: invalid Go generated: %s", err)
-			log.Printf("warning: compile the package to analyze the error")
-			src = buf.Bytes()
+	// Nested elements named after a generated datatype use its type
+	for _, datatype := range datatypes {
+		delete(nested, cleanname(datatype))
+	}
+	if len(nested) > 0 {
+		var types []*nestedType
+		for _, t := range nested {
+			sort.Strings(t.Datatypes)
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool {
+			return types[i].Name < types[j].Name
+		})
+		var context = struct {
+			PackageName string
+			Types       []*nestedType
+		}{
+			PackageName: g.pkg,
+			Types:       types,
 		}
 
-		output := strings.ToLower(g.outputPrefix + context.TypeName + g.outputSuffix + ".go")
-		outputPath := filepath.Join(g.dir, output)
-		if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
-			log.Fatalf("writing output: %s", err)
+		output := g.typesFile()
+		src, err := render(typesTmpl, context)
+		if src == nil {
+			return nil, fmt.Errorf("%s: %v", output, err)
+		}
+		files[output] = src
+		if err != nil && invalid == nil {
+			invalid = fmt.Errorf("%s: %v", output, err)
 		}
 	}
+	return files, invalid
 }
 
-func (g *generator) GenerateCommonFile() {
-	var buf bytes.Buffer
-	datatypes := strings.Split(g.objectNames, ",")
-	sort.Slice(datatypes, func(i, j int) bool {
-		return strings.Compare(datatypes[i], datatypes[j]) == -1
-	})
-	var context = struct {
-		PackageName string
-		Types       []string
-	}{
-		PackageName: g.pkg,
-		Types:       datatypes,
-	}
+// typesFile is the name of the file of the nested types
+func (g *generator) typesFile() string {
+	return strings.ToLower(g.outputPrefix + "types" + g.outputSuffix + ".go")
+}
 
-	if err := commonTmpl.Execute(&buf, context); err != nil {
-		log.Fatalf("generating code: %v", err)
+// nestedType is the type of an element nested in one or more datatypes
+type nestedType struct {
+	Name      string
+	RawName   string
+	Fields    []field
+	Datatypes []string
+}
+
+// addNestedTypes adds the types of the nested elements in fields to types,
+// at any depth. Elements with the same type name share one definition with
+// the fields of all of them.
+func addNestedTypes(types map[string]*nestedType, datatype string, fields []field) {
+	for _, f := range fields {
+		if f.Elements == nil {
+			continue
+		}
+		name := strings.TrimPrefix(f.FieldType, "[]")
+		raw := f.RawName
+		if f.Path != "" {
+			path := strings.Split(f.Path, ">")
+			raw = path[len(path)-1]
+		}
+		t, ok := types[name]
+		if !ok {
+			t = &nestedType{Name: name, RawName: raw}
+			types[name] = t
+		}
+		if !contains(t.Datatypes, datatype) {
+			t.Datatypes = append(t.Datatypes, datatype)
+		}
+		for _, e := range f.Elements {
+			i := indexField(t.Fields, e.RawName)
+			switch {
+			case i < 0:
+				t.Fields = append(t.Fields, e)
+			case t.Fields[i].FieldType == "string" && e.FieldType != "string":
+				// Another datatype's samples gave the field a type
+				t.Fields[i] = e
+			}
+		}
+		t.Fields = nameFields(t.Fields)
+		addNestedTypes(types, datatype, f.Elements)
 	}
+}
 
-	src, err := format.Source(buf.Bytes())
-	if err != nil {
-		log.Printf("warning: internal error: invalid Go generated: %s", err)
-		log.Printf("warning: compile the package to analyze the error")
-		src = buf.Bytes()
+func indexField(fields []field, raw string) int {
+	for i, f := range fields {
+		if f.RawName == raw {
+			return i
+		}
 	}
+	return -1
+}
 
-	output := strings.ToLower(g.outputPrefix + "common" + g.outputSuffix + ".go")
-	outputPath := filepath.Join(g.dir, output)
-	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
-		log.Fatalf("writing output: %s", err)
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
 }
 
-func (g *generator) GenerateCommonTestFile() {
-	var buf bytes.Buffer
-	datatypes := strings.Split(g.objectNames, ",")
-	sort.Slice(datatypes, func(i, j int) bool {
-		return strings.Compare(datatypes[i], datatypes[j]) == -1
-	})
+// commonFile renders the file shared by all datatypes
+func (g *generator) commonFile(ctx context.Context) (map[string][]byte, error) {
+	return g.renderCommon(ctx, commonTmpl, "common"+g.outputSuffix+".go")
+}
+
+// commonTestFile renders the tests of the common file
+func (g *generator) commonTestFile(ctx context.Context) (map[string][]byte, error) {
+	return g.renderCommon(ctx, commonTestTmpl, "common"+g.outputSuffix+"_test.go")
+}
+
+func (g *generator) renderCommon(ctx context.Context, tmpl *template.Template, name string) (map[string][]byte, error) {
+	datatypes, err := g.datatypes(ctx)
+	if err != nil {
+		return nil, err
+	}
 	var context = struct {
 		PackageName string
 		Types       []string
@@ -244,22 +1153,52 @@ func (g *generator) GenerateCommonTestFile() {
 		Types:       datatypes,
 	}
 
-	if err := commonTestTmpl.Execute(&buf, context); err != nil {
-		log.Fatalf("generating code: %v", err)
+	output := strings.ToLower(g.outputPrefix + name)
+	src, err := render(tmpl, context)
+	if src == nil {
+		return nil, fmt.Errorf("%s: %v", output, err)
+	}
+	if err != nil {
+		return map[string][]byte{output: src}, fmt.Errorf("%s: %v", output, err)
 	}
+	return map[string][]byte{output: src}, nil
+}
 
+// render executes tmpl and formats the result. When the result isn't valid
+// Go, it is returned unformatted along with the error, so that it can be
+// written out and compiled to analyze the error.
+func render(tmpl *template.Template, context interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, context); err != nil {
+		return nil, fmt.Errorf("generating code: %v", err)
+	}
 	src, err := format.Source(buf.Bytes())
 	if err != nil {
-		log.Printf("warning: internal error: invalid Go generated: %s", err)
-		log.Printf("warning: compile the package to analyze the error")
-		src = buf.Bytes()
+		return buf.Bytes(), fmt.Errorf("internal error: invalid Go generated: %v", err)
+	}
+	return src, nil
+}
+
+// write writes the files rendered with err to the generator's directory.
+// Files that aren't valid Go are still written, so the package can be
+// compiled to analyze the error, which is then returned.
+func (g *generator) write(files map[string][]byte, err error) error {
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(g.dir, name), src, 0644); err != nil {
+			return fmt.Errorf("writing output: %s", err)
+		}
 	}
+	return err
+}
 
-	output := strings.ToLower(g.outputPrefix + "common" + g.outputSuffix + "_test.go")
-	outputPath := filepath.Join(g.dir, output)
-	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
-		log.Fatalf("writing output: %s", err)
+// remove removes the named files from the generator's directory
+func (g *generator) remove(names []string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(g.dir, name)); err != nil {
+			return fmt.Errorf("removing output: %s", err)
+		}
 	}
+	return nil
 }
 
 // Element contains an element
@@ -283,9 +1222,9 @@ type Auth struct {
 
 // Read is a container for OpenAir entities
 type Read struct {
-	XMLName xml.Name `xml:"Read"`
-	Status  string   `xml:"status,attr"`
-	Entity  Element  `xml:",any"`
+	XMLName  xml.Name  `xml:"Read"`
+	Status   string    `xml:"status,attr"`
+	Entities []Element `xml:",any"`
 }
 
 // GetPackageName finds the package name for the given directory
@@ -0,0 +1,613 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAir", func() {
+	Describe("parseFields()", func() {
+		It("builds fields from a captured response", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			fields, err := parseFields(body, false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "ID", RawName: "id", FieldType: "string", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "BillingContactID", RawName: "billing_contact_id", FieldType: "string", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Addr", RawName: "addr", FieldType: Address, Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Updated", RawName: "updated", FieldType: Date, Comment: "set in the only sample"}))
+		})
+
+		It("names custom fields without their suffix", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			fields, err := parseFields(body, false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "AccountManager", RawName: "account_manager__c", FieldType: "string", Custom: true, Comment: "set in the only sample"}))
+		})
+
+		It("adds a deleted field when the response does not include one", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			fields, err := parseFields(body, false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "Deleted", RawName: "deleted", FieldType: "string", Comment: "not in the samples"}))
+		})
+
+		It("infers types from the sample values when typed", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Project"))
+			Ω(err).ShouldNot(HaveOccurred())
+			fields, err := parseFields(body, true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "ID", RawName: "id", FieldType: "int64", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Updated", RawName: "updated", FieldType: Time, Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "CustomerID", RawName: "customerid", FieldType: "int64", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Active", RawName: "active", FieldType: "string", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Budget", RawName: "budget", FieldType: "float64", Comment: "set in the only sample"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Name", RawName: "name", FieldType: "string", Comment: "set in the only sample"}))
+		})
+
+		It("merges the fields of every sample", func() {
+			body := []byte(`<response><Auth status="0"></Auth><Read status="0">` +
+				`<Project><id>1</id><notes></notes><budget>10</budget></Project>` +
+				`<Project><id>2</id><notes>Late</notes><budget>12.50</budget><start_date><Date><year>2017</year></Date></start_date></Project>` +
+				`<Project><id>3</id><budget>abc</budget><start_date></start_date></Project>` +
+				`</Read></response>`)
+			fields, err := parseFields(body, true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "ID", RawName: "id", FieldType: "int64", Comment: "set in all 3 samples"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Notes", RawName: "notes", FieldType: "string", Comment: "set in 1 of 3 samples"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "StartDate", RawName: "start_date", FieldType: Time, Comment: "set in 1 of 3 samples"}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Budget", RawName: "budget", FieldType: "string", Comment: "set in all 3 samples; values disagree, so string"}))
+		})
+
+		It("builds nested types for elements with child elements", func() {
+			body := []byte(`<response><Auth status="0"></Auth><Read status="0">` +
+				`<Project><id>1</id><flags><Flag><name>billable</name><setting>1</setting></Flag><Flag><name>closed</name><setting>0</setting></Flag></flags>` +
+				`<contact><name>Ada</name><start><Date><year>2017</year></Date></start></contact><Address><Address><city>Boston</city></Address></Address></Project>` +
+				`<Project><id>2</id><flags></flags><contact><name>Grace</name><email>grace@example.com</email></contact></Project>` +
+				`</Read></response>`)
+			fields, err := parseFields(body, true)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fields).Should(ContainElement(field{FieldName: "Flags", RawName: "flags", FieldType: "[]Flag", Path: "flags>Flag", Comment: "set in 1 of 2 samples", Elements: []field{
+				{FieldName: "Name", RawName: "name", FieldType: "string", Comment: "set in all 2 samples"},
+				{FieldName: "Setting", RawName: "setting", FieldType: "bool", Comment: "set in all 2 samples"},
+			}}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Contact", RawName: "contact", FieldType: "Contact", Comment: "set in all 2 samples", Elements: []field{
+				{FieldName: "Email", RawName: "email", FieldType: "string", Comment: "set in 1 of 2 samples"},
+				{FieldName: "Name", RawName: "name", FieldType: "string", Comment: "set in all 2 samples"},
+				{FieldName: "Start", RawName: "start", FieldType: Time, Comment: "set in 1 of 2 samples"},
+			}}))
+			Ω(fields).Should(ContainElement(field{FieldName: "Address", RawName: "Address", FieldType: Address, Comment: "set in 1 of 2 samples"}))
+		})
+
+		It("returns an error when authentication failed", func() {
+			_, err := parseFields([]byte(`<response><Auth status="401"></Auth></response>`), false)
+			Ω(err).Should(MatchError("authentication failed with status 401"))
+		})
+
+		It("returns an error when the read failed", func() {
+			_, err := parseFields([]byte(`<response><Auth status="0"></Auth><Read status="601"></Read></response>`), false)
+			Ω(err).Should(MatchError("read failed with status 601"))
+		})
+	})
+
+	Describe("inferTypes()", func() {
+		It("picks the most specific type that fits every value", func() {
+			t, consistent := inferTypes("Active", []string{"0", "1"})
+			Ω(t).Should(Equal("bool"))
+			Ω(consistent).Should(BeTrue())
+			t, _ = inferTypes("Budget", []string{"0", "12.50"})
+			Ω(t).Should(Equal("float64"))
+			t, _ = inferTypes("UserID", []string{"1", "42"})
+			Ω(t).Should(Equal("int64"))
+		})
+
+		It("only makes flags bool when the samples have both 0 and 1", func() {
+			t, consistent := inferTypes("Active", []string{"1", "1"})
+			Ω(t).Should(Equal("string"))
+			Ω(consistent).Should(BeTrue())
+			t, _ = inferTypes("Priority", []string{"0"})
+			Ω(t).Should(Equal("string"))
+			t, _ = inferTypes("Active", []string{"1", "0", "1"})
+			Ω(t).Should(Equal("bool"))
+		})
+
+		It("falls back to string", func() {
+			t, consistent := inferTypes("Name", nil)
+			Ω(t).Should(Equal("string"))
+			Ω(consistent).Should(BeTrue())
+			t, consistent = inferTypes("Name", []string{"Acme", "Anvil"})
+			Ω(t).Should(Equal("string"))
+			Ω(consistent).Should(BeTrue())
+			t, consistent = inferTypes("Priority", []string{"1", "5"})
+			Ω(t).Should(Equal("string"))
+			Ω(consistent).Should(BeFalse())
+		})
+	})
+
+	Describe("sampleComment()", func() {
+		It("says how many samples set the field", func() {
+			Ω(sampleComment(0, 0)).Should(Equal("not in the samples"))
+			Ω(sampleComment(0, 1)).Should(Equal("empty in the only sample"))
+			Ω(sampleComment(1, 1)).Should(Equal("set in the only sample"))
+			Ω(sampleComment(0, 10)).Should(Equal("empty in all 10 samples"))
+			Ω(sampleComment(10, 10)).Should(Equal("set in all 10 samples"))
+			Ω(sampleComment(7, 10)).Should(Equal("set in 7 of 10 samples"))
+		})
+	})
+
+	Describe("inferType()", func() {
+		It("only makes ids int64", func() {
+			Ω(inferType("CustomerID", "42")).Should(Equal("int64"))
+			Ω(inferType("CustomerID", "1")).Should(Equal("int64"))
+			Ω(inferType("Zip", "02110")).Should(Equal("string"))
+		})
+
+		It("makes 0 and 1 flags bool", func() {
+			Ω(inferType("Active", "1")).Should(Equal("bool"))
+			Ω(inferType("Deleted", "0")).Should(Equal("bool"))
+		})
+
+		It("makes decimal values float64", func() {
+			Ω(inferType("Budget", "1500.00")).Should(Equal("float64"))
+			Ω(inferType("Hours", "-0.5")).Should(Equal("float64"))
+		})
+
+		It("falls back to string", func() {
+			Ω(inferType("Name", "")).Should(Equal("string"))
+			Ω(inferType("Name", "Acme")).Should(Equal("string"))
+		})
+	})
+
+	Describe("parseCustomFields()", func() {
+		It("types custom fields by datatype", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "CustomField"))
+			Ω(err).ShouldNot(HaveOccurred())
+			definitions, err := parseCustomFields(body)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(definitions).Should(Equal(map[string]map[string]string{
+				"customer": {"account_manager__c": "string", "strategic__c": "bool"},
+				"project":  {"margin__c": "float64"},
+			}))
+		})
+
+		It("returns an error when the read failed", func() {
+			_, err := parseCustomFields([]byte(`<response><Auth status="0"></Auth><Read status="416"></Read></response>`))
+			Ω(err).Should(MatchError("read failed with status 416"))
+		})
+	})
+
+	Describe("applyDefinitions()", func() {
+		It("types the custom fields that have a definition", func() {
+			fields := []field{
+				{FieldName: "Strategic", RawName: "strategic__c", FieldType: "string", Custom: true},
+				{FieldName: "Renewal", RawName: "renewal__c", FieldType: Date, Custom: true},
+				{FieldName: "Name", RawName: "name", FieldType: "string"},
+			}
+			applyDefinitions(fields, map[string]string{"strategic__c": "bool", "renewal__c": "string"})
+			Ω(fields[0].FieldType).Should(Equal("bool"))
+			Ω(fields[1].FieldType).Should(Equal(Date))
+			Ω(fields[2].FieldType).Should(Equal("string"))
+		})
+	})
+
+	Describe("applyOverrides()", func() {
+		var fields []field
+
+		BeforeEach(func() {
+			fields = []field{
+				{FieldName: "Budget", RawName: "budget", FieldType: "string"},
+				{FieldName: "ID", RawName: "id", FieldType: "string"},
+				{FieldName: "Notes", RawName: "notes", FieldType: "string"},
+				{FieldName: "PaID", RawName: "paid", FieldType: "string"},
+			}
+		})
+
+		It("changes the type, name and tags of fields", func() {
+			result, err := applyOverrides("Project", fields, map[string]Override{
+				"Project.budget": {Type: "float64"},
+				"Project.paid":   {Name: "Paid", Tags: `xml:"paid" json:"-"`},
+				"Customer.notes": {Skip: true},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result).Should(Equal([]field{
+				{FieldName: "Budget", RawName: "budget", FieldType: "float64", Comment: "typed by override"},
+				{FieldName: "ID", RawName: "id", FieldType: "string"},
+				{FieldName: "Notes", RawName: "notes", FieldType: "string"},
+				{FieldName: "Paid", RawName: "paid", FieldType: "string", Tag: `xml:"paid" json:"-"`},
+			}))
+		})
+
+		It("skips fields", func() {
+			result, err := applyOverrides("Project", fields, map[string]Override{"Project.notes": {Skip: true}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(hasField(result, "notes")).Should(BeFalse())
+		})
+
+		It("returns an error for a field the datatype doesn't have", func() {
+			_, err := applyOverrides("Project", fields, map[string]Override{"Project.budgte": {Type: "float64"}})
+			Ω(err).Should(MatchError("override Project.budgte: Project has no such field"))
+		})
+
+		It("returns an error when renaming a field the generated methods use", func() {
+			_, err := applyOverrides("Project", fields, map[string]Override{"Project.id": {Name: "Key"}})
+			Ω(err).Should(MatchError("override Project.id: the id field can't be renamed"))
+		})
+
+		It("returns an error for an id or deleted type the generated methods can't use", func() {
+			fields = append(fields, field{FieldName: "Deleted", RawName: "deleted", FieldType: "string"})
+			_, err := applyOverrides("Project", fields, map[string]Override{"Project.id": {Type: "int"}})
+			Ω(err).Should(MatchError("override Project.id: the type of the id field must be one of string, int64"))
+			_, err = applyOverrides("Project", fields, map[string]Override{"Project.deleted": {Type: "Time"}})
+			Ω(err).Should(MatchError("override Project.deleted: the type of the deleted field must be one of string, bool, int64"))
+
+			result, err := applyOverrides("Project", fields, map[string]Override{
+				"Project.id":      {Type: "int64"},
+				"Project.deleted": {Type: "bool"},
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fieldType(result, "id")).Should(Equal("int64"))
+			Ω(fieldType(result, "deleted")).Should(Equal("bool"))
+		})
+
+		It("returns an error when two fields end up with the same name", func() {
+			_, err := applyOverrides("Project", fields, map[string]Override{"Project.paid": {Name: "Notes"}})
+			Ω(err).Should(MatchError("Project: more than one field is named Notes"))
+		})
+	})
+
+	Describe("unusedOverrides()", func() {
+		It("returns an error for each override of a datatype that isn't generated", func() {
+			errs := unusedOverrides([]string{"Project"}, map[string]Override{
+				"Project.budget": {Type: "float64"},
+				"Projct.budget":  {Type: "float64"},
+				"Nope.x":         {Skip: true},
+			})
+			Ω(errs).Should(MatchError("override Nope.x: Nope is not one of the datatypes being generated\n" +
+				"override Projct.budget: Projct is not one of the datatypes being generated"))
+		})
+	})
+
+	Describe("LoadOverrides()", func() {
+		It("reads objects and the skip shorthand", func() {
+			f, err := ioutil.TempFile("", "overrides")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.Remove(f.Name())
+			_, err = f.WriteString(`{"Project.budget": {"type": "float64"}, "Project.notes": "skip"}`)
+			Ω(err).ShouldNot(HaveOccurred())
+			f.Close()
+
+			overrides, err := LoadOverrides(f.Name())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(overrides).Should(Equal(map[string]Override{
+				"Project.budget": {Type: "float64"},
+				"Project.notes":  {Skip: true},
+			}))
+		})
+	})
+
+	Describe("hasField()", func() {
+		It("matches on the raw name", func() {
+			fields := []field{{FieldName: "ID", RawName: "id", FieldType: "string"}}
+			Ω(hasField(fields, "id")).Should(BeTrue())
+			Ω(hasField(fields, "ID")).Should(BeFalse())
+		})
+	})
+
+	Describe("hasDateField()", func() {
+		It("only matches Date fields", func() {
+			fields := []field{
+				{FieldName: "Updated", RawName: "updated", FieldType: Date},
+				{FieldName: "Name", RawName: "name", FieldType: "string"},
+			}
+			Ω(hasDateField(fields, "updated")).Should(BeTrue())
+			Ω(hasDateField(fields, "name")).Should(BeFalse())
+			Ω(hasDateField([]field{{FieldName: "Updated", RawName: "updated", FieldType: Time}}, "updated")).Should(BeTrue())
+		})
+	})
+
+	Describe("GenerateModelFiles()", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "definition.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("generates models from a schema directory without contacting OpenAir", func() {
+			g, err := New(Config{}, Options{
+				ObjectNames:  "Project,Customer",
+				Dir:          dir,
+				OutputSuffix: "_openair",
+				SchemaDir:    "testdata/schema",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+
+			src, err := ioutil.ReadFile(filepath.Join(dir, "customer_openair.go"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(src)).Should(ContainSubstring("type Customer struct"))
+			Ω(filepath.Join(dir, "project_openair.go")).Should(BeAnExistingFile())
+		})
+
+		It("groups custom fields and types them from their definitions", func() {
+			g, err := New(Config{}, Options{
+				ObjectNames:  "Customer",
+				Dir:          dir,
+				OutputSuffix: "_openair",
+				SchemaDir:    "testdata/schema",
+				CustomFields: true,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+
+			src, err := ioutil.ReadFile(filepath.Join(dir, "customer_openair.go"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(src)).Should(ContainSubstring("type CustomerCustom struct"))
+			Ω(string(src)).Should(MatchRegexp(`Strategic\s+bool\s+` + "`" + `xml:"strategic__c,omitempty"`))
+		})
+
+		It("removes the types file once no datatype has nested types", func() {
+			schemaDir := writeNestedSchemas(dir)
+			g, err := New(Config{}, Options{ObjectNames: "Project", Dir: dir, OutputSuffix: "_openair", SchemaDir: schemaDir})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+			Ω(filepath.Join(dir, "types_openair.go")).Should(BeAnExistingFile())
+
+			g, err = New(Config{}, Options{ObjectNames: "Project,Customer", Dir: dir, OutputSuffix: "_openair", SchemaDir: schemaDir})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+			Ω(filepath.Join(dir, "types_openair.go")).ShouldNot(BeAnExistingFile())
+			Ω(filepath.Join(dir, "customer_openair.go")).Should(BeAnExistingFile())
+		})
+
+		It("returns an error for a datatype that can't be read", func() {
+			g, err := New(Config{}, Options{
+				ObjectNames:  "Invoice",
+				Dir:          dir,
+				OutputSuffix: "_openair",
+				SchemaDir:    "testdata/schema",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(MatchError(ContainSubstring("Invoice: open")))
+		})
+	})
+
+	Describe("GenerateFiles()", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "definition.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("writes the common and model files", func() {
+			g, err := New(Config{}, Options{ObjectNames: "Customer", Dir: dir, OutputSuffix: "_openair", SchemaDir: "testdata/schema"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(Succeed())
+			Ω(filepath.Join(dir, "common_openair.go")).Should(BeAnExistingFile())
+			Ω(filepath.Join(dir, "common_openair_test.go")).Should(BeAnExistingFile())
+			Ω(filepath.Join(dir, "customer_openair.go")).Should(BeAnExistingFile())
+		})
+
+		It("removes generated files that are no longer generated", func() {
+			schemaDir := writeNestedSchemas(dir)
+			g, err := New(Config{}, Options{ObjectNames: "Project", Dir: dir, OutputSuffix: "_openair", SchemaDir: schemaDir})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(Succeed())
+			src, err := ioutil.ReadFile(filepath.Join(dir, "types_openair.go"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(src)).Should(ContainSubstring("type Customer struct"))
+
+			// Customer is now generated in its own file, so the types file
+			// would declare it again
+			g, err = New(Config{}, Options{ObjectNames: "Project,Customer", Dir: dir, OutputSuffix: "_openair", SchemaDir: schemaDir})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(Succeed())
+			Ω(filepath.Join(dir, "types_openair.go")).ShouldNot(BeAnExistingFile())
+
+			g, err = New(Config{}, Options{ObjectNames: "Project", Dir: dir, OutputSuffix: "_openair", SchemaDir: schemaDir})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(Succeed())
+			Ω(filepath.Join(dir, "customer_openair.go")).ShouldNot(BeAnExistingFile())
+			Ω(filepath.Join(dir, "types_openair.go")).Should(BeAnExistingFile())
+			Ω(filepath.Join(dir, "definition.go")).Should(BeAnExistingFile())
+		})
+
+		It("writes nothing when a datatype can't be read", func() {
+			g, err := New(Config{}, Options{ObjectNames: "Customer,Invoice", Dir: dir, OutputSuffix: "_openair", SchemaDir: "testdata/schema"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateFiles(context.Background())).Should(MatchError(ContainSubstring("Invoice: open")))
+			files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(ConsistOf(filepath.Join(dir, "definition.go")))
+		})
+	})
+
+	Describe("GenerateModelFiles() with OpenAir", func() {
+		var (
+			dir      string
+			server   *httptest.Server
+			mu       sync.Mutex
+			inFlight int
+			peak     int
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			err = ioutil.WriteFile(filepath.Join(dir, "definition.go"), []byte("package openair\n"), 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			inFlight, peak = 0, 0
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				if inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+
+				request, _ := ioutil.ReadAll(r.Body)
+				if strings.Contains(string(request), `type="Invoice"`) || strings.Contains(string(request), `type="Agreement"`) {
+					w.Write([]byte(`<response><Auth status="0"></Auth><Read status="603"></Read></response>`))
+					return
+				}
+				w.Write(body)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		options := func(objectNames string) Options {
+			return Options{ObjectNames: objectNames, Dir: dir, OutputSuffix: "_openair", Concurrency: 2}
+		}
+
+		It("reads schemas concurrently, at most Concurrency at a time", func() {
+			g, err := New(Config{Scheme: "http", Domain: strings.TrimPrefix(server.URL, "http://")}, options("Customer,Project,Task,Timesheet,User,Vendor"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(g.GenerateModelFiles(context.Background())).Should(Succeed())
+
+			Ω(peak).Should(Equal(2))
+			for _, name := range []string{"customer", "project", "task", "timesheet", "user", "vendor"} {
+				Ω(filepath.Join(dir, name+"_openair.go")).Should(BeAnExistingFile())
+			}
+		})
+
+		It("returns the errors of every datatype that failed, in order", func() {
+			g, err := New(Config{Scheme: "http", Domain: strings.TrimPrefix(server.URL, "http://")}, options("Invoice,Customer,Agreement"))
+			Ω(err).ShouldNot(HaveOccurred())
+			err = g.GenerateModelFiles(context.Background())
+			Ω(err).Should(MatchError("Agreement: read failed with status 603\nInvoice: read failed with status 603"))
+			Ω(err.(Errors)).Should(HaveLen(2))
+			Ω(filepath.Join(dir, "customer_openair.go")).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("New()", func() {
+		It("returns an error when the directory has no Go files", func() {
+			dir, err := ioutil.TempDir("", "openair")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			_, err = New(Config{}, Options{Dir: dir})
+			Ω(err).Should(MatchError(ContainSubstring("cannot process directory")))
+		})
+	})
+
+	Describe("Generate()", func() {
+		It("generates sources from schemas in memory", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			files, err := Generate(context.Background(), "openair", map[string][]byte{"Customer": body}, Options{OutputPrefix: "openair_"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveLen(3))
+			Ω(files).Should(HaveKey("openair_common.go"))
+			Ω(files).Should(HaveKey("openair_common_test.go"))
+			Ω(string(files["openair_customer.go"])).Should(ContainSubstring("package openair"))
+			Ω(string(files["openair_customer.go"])).Should(ContainSubstring("type Customer struct"))
+		})
+
+		It("shares one definition of a nested type across datatypes", func() {
+			schemas := map[string][]byte{
+				"Project": []byte(`<response><Auth status="0"></Auth><Read status="0">` +
+					`<Project><id>1</id><flags><Flag><name>billable</name></Flag></flags><customer><Customer><id>2</id></Customer></customer></Project>` +
+					`</Read></response>`),
+				"Timesheet": []byte(`<response><Auth status="0"></Auth><Read status="0">` +
+					`<Timesheet><id>3</id><flags><Flag><setting>1</setting></Flag></flags></Timesheet>` +
+					`</Read></response>`),
+				"Customer": []byte(`<response><Auth status="0"></Auth><Read status="0">` +
+					`<Customer><id>2</id><name>Acme</name></Customer>` +
+					`</Read></response>`),
+			}
+			files, err := Generate(context.Background(), "openair", schemas, Options{})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(files).Should(HaveKey("types.go"))
+			Ω(string(files["project.go"])).Should(MatchRegexp(`Flags\s+\[\]Flag\s+` + "`" + `xml:"flags>Flag,omitempty" json:"flags,omitempty"`))
+			Ω(string(files["project.go"])).Should(MatchRegexp(`Customer\s+Customer\s+` + "`" + `xml:"customer>Customer,omitempty" json:"customer,omitempty"`))
+			Ω(string(files["timesheet.go"])).Should(MatchRegexp(`Flags\s+\[\]Flag\s+` + "`" + `xml:"flags>Flag,omitempty" json:"flags,omitempty"`))
+
+			types := string(files["types.go"])
+			Ω(types).Should(ContainSubstring("// Flag is the Flag element nested in Project, Timesheet\ntype Flag struct"))
+			Ω(types).Should(MatchRegexp(`Name\s+string\s+` + "`" + `xml:"name,omitempty" json:"name,omitempty"`))
+			Ω(types).Should(MatchRegexp(`Setting\s+string\s+` + "`" + `xml:"setting,omitempty" json:"setting,omitempty"`))
+			Ω(types).ShouldNot(ContainSubstring("type Customer struct"))
+		})
+
+		It("returns an error for an override of a datatype that isn't generated", func() {
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = Generate(context.Background(), "openair", map[string][]byte{"Customer": body}, Options{
+				Overrides: map[string]Override{"Custmer.notes": {Skip: true}},
+			})
+			Ω(err).Should(MatchError("override Custmer.notes: Custmer is not one of the datatypes being generated"))
+		})
+
+		It("returns an error for a datatype without a schema", func() {
+			_, err := Generate(context.Background(), "openair", map[string][]byte{}, Options{ObjectNames: "Customer"})
+			Ω(err).Should(MatchError(ContainSubstring("no schema for Customer")))
+		})
+
+		It("stops when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := Generate(ctx, "openair", map[string][]byte{"Customer": []byte("<response/>")}, Options{})
+			Ω(err).Should(Equal(context.Canceled))
+		})
+	})
+})
+
+// writeNestedSchemas writes schemas of a Project that nests a Customer, and
+// of Customer, to a schema directory in dir, and returns it
+func writeNestedSchemas(dir string) string {
+	schemaDir := filepath.Join(dir, "schema")
+	Ω(os.Mkdir(schemaDir, 0755)).Should(Succeed())
+	schemas := map[string]string{
+		"Project": `<response><Auth status="0"></Auth><Read status="0">` +
+			`<Project><id>1</id><customer><Customer><id>2</id><name>Acme</name></Customer></customer></Project>` +
+			`</Read></response>`,
+		"Customer": `<response><Auth status="0"></Auth><Read status="0">` +
+			`<Customer><id>2</id><name>Acme</name></Customer>` +
+			`</Read></response>`,
+	}
+	for datatype, body := range schemas {
+		Ω(ioutil.WriteFile(SchemaPath(schemaDir, datatype), []byte(body), 0644)).Should(Succeed())
+	}
+	return schemaDir
+}
@@ -60,18 +60,32 @@ func cleanname(name string) string {
 	return strings.Title(name)
 }
 
+// tag returns the struct tag of a field at the XML path tagname, such as
+// flags>Flag. The JSON name is the first element of the path.
 func tag(tagname string, t string) string {
 	xmlname := tagname
-	jsonname := tagname
+	jsonname := strings.Split(tagname, ">")[0]
 	if strings.ToLower(t) == strings.ToLower(Address) {
 		xmlname = tagname + ">" + Address
 	}
-	if strings.ToLower(t) == strings.ToLower(Date) {
+	if strings.ToLower(t) == strings.ToLower(Date) || t == Time {
 		xmlname = tagname + ">" + Date
 	}
 	return "`xml:\"" + xmlname + ",omitempty\" json:\"" + jsonname + ",omitempty\"`"
 }
 
+// fieldtag returns the struct tag of f, either its override or the tag
+// derived from its XML path and type
+func fieldtag(f field) string {
+	if f.Tag != "" {
+		return "`" + f.Tag + "`"
+	}
+	if f.Path != "" {
+		return tag(f.Path, f.FieldType)
+	}
+	return tag(f.RawName, f.FieldType)
+}
+
 func xmltag(tagName string) string {
 	return "`xml:\"" + tagName + "\"`"
 }
@@ -94,12 +108,15 @@ func backtick() string {
 
 var generatedTmpl = template.Must(template.New("generated").Funcs(template.FuncMap{
 	"tag":            tag,
+	"fieldtag":       fieldtag,
 	"backtick":       backtick,
 	"xmltag":         xmltag,
 	"xmlrawtag":      xmlrawtag,
 	"cleanname":      cleanname,
 	"cleannamelower": cleannamelower,
 	"tolower":        strings.ToLower,
+	"isdate":         isDate,
+	"zero":           zero,
 }).Parse(`
 // Code generated by openair; DO NOT EDIT.
 
@@ -108,16 +125,43 @@ package {{.PackageName}}
 import (
 	"context"
 	"encoding/xml"
-	"errors"
+	{{if .HasID}}"errors"{{end}}
 	"fmt"
+	"sync"
 	"time"
-	"net/http"
-	"strings"
 )
 
 // {{cleanname .TypeName}} is the {{.TypeName}} OpenAir XML Datatype
 type {{cleanname .TypeName}} struct {
-	{{range .Fields}}{{cleanname .FieldName}} {{.FieldType}} {{tag .RawName .FieldType}}
+	{{if .Custom}}{{cleanname .TypeName}}Custom
+	{{end}}{{range .Fields}}{{.FieldName}} {{.FieldType}} {{fieldtag .}}{{with .Comment}} // {{.}}{{end}}
+	{{end}}
+}
+{{if .Custom}}
+// {{cleanname .TypeName}}Custom holds the custom fields of {{cleanname .TypeName}}. Its
+// fields are promoted, so they can be used as {{cleanname .TypeName}} fields.
+type {{cleanname .TypeName}}Custom struct {
+	{{range .Custom}}{{.FieldName}} {{.FieldType}} {{fieldtag .}}{{with .Comment}} // {{.}}{{end}}
+	{{end}}
+}
+{{end}}
+// {{cleanname .TypeName}}Fields identifies the fields of {{cleanname .TypeName}}, e.g. for Modify
+var {{cleanname .TypeName}}Fields = struct {
+	{{range .Fields}}{{.FieldName}} Field
+	{{end}}{{if .Custom}}Custom struct {
+		{{range .Custom}}{{.FieldName}} Field
+		{{end}}
+	}
+	{{end}}
+}{
+	{{range .Fields}}{{.FieldName}}: "{{.RawName}}",
+	{{end}}{{if .Custom}}Custom: struct {
+		{{range .Custom}}{{.FieldName}} Field
+		{{end}}
+	}{
+		{{range .Custom}}{{.FieldName}}: "{{.RawName}}",
+		{{end}}
+	},
 	{{end}}
 }
 
@@ -135,136 +179,460 @@ type {{cleanname .TypeName}}Read struct {
 }
 
 type {{cleannamelower .TypeName}} struct {
-	config *Config
+	api *API
+}
+
+// {{cleannamelower .TypeName}}DateFields are the fields of {{cleanname .TypeName}} that a Query can filter by date
+var {{cleannamelower .TypeName}}DateFields = map[Field]bool{
+	{{range .Fields}}{{if isdate .FieldType}}"{{.RawName}}": true,
+	{{end}}{{end}}{{range .Custom}}{{if isdate .FieldType}}"{{.RawName}}": true,
+	{{end}}{{end}}
 }
 
-func (o *{{cleannamelower .TypeName}}) list(ctx context.Context, limit int, offset int, modifiedSince *time.Time, deleted bool) ([]{{cleanname .TypeName}}, error) {
-	url := fmt.Sprintf("%s://%s/api.pl", o.config.Scheme, o.config.Domain)
+// check returns an error if q can't be used to read {{cleanname .TypeName}} records
+func (o *{{cleannamelower .TypeName}}) check(q *Query) error {
+	if q == nil {
+		return nil
+	}
+	if q.err != nil {
+		return q.err
+	}
+	for _, f := range q.filters {
+		if !{{cleannamelower .TypeName}}DateFields[f.field] {
+			return fmt.Errorf("openair: %s is not a Date field of {{.RawTypeName}}", f.field)
+		}
+	}
+	return nil
+}
 
-	filterAttributes := ""
-	filterBody := ""
+func (o *{{cleannamelower .TypeName}}) readCommand(q *Query, limit int, offset int, deleted bool) (Command, error) {
+	if err := o.check(q); err != nil {
+		return Command{}, err
+	}
+	if q == nil {
+		q = NewQuery()
+	}
+	loc, err := time.LoadLocation(o.api.config.Timezone)
+	if err != nil {
+		return Command{}, err
+	}
 
 	nonDeletedFlag, deletedFlag := 1, 0
 	if deleted {
 		nonDeletedFlag, deletedFlag = deletedFlag, nonDeletedFlag
 	}
 
-	if modifiedSince != nil {
-		filterAttributes = "filter=\"newer-than\" field=\"updated\""
-		filterBody = fmt.Sprintf({{backtick}}<Date>
-			<year>%d</year>
-			<month>%d</month>
-			<day>%d</day>
-		</Date>{{backtick}}, modifiedSince.Year(), modifiedSince.Month(), modifiedSince.Day())
-	}
-
-	tmpl := {{backtick}}<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
-		<request API_version="1.0" client_ver="1.1" namespace="%s" key="%s">
-			<Auth>
-				<Login>
-					<company>%s</company>
-					<user>%s</user>
-					<password>%s</password>
-				</Login>
-			</Auth>
-			<Read type="{{.RawTypeName}}" method="all" limit="%d,%d" enable_custom="1" include_nondeleted="%d" deleted="%d" %s>%s</Read>
-		</request>{{backtick}}
+	tmpl := {{backtick}}<Read type="{{.RawTypeName}}" method="%s" limit="%d,%d" enable_custom="1" include_nondeleted="%d" deleted="%d"%s>%s%s</Read>{{backtick}}
+	return Command{
+		name:     "Read",
+		datatype: "{{.RawTypeName}}",
+		xml:      fmt.Sprintf(tmpl, q.method, offset, limit, nonDeletedFlag, deletedFlag, q.filterAttributes(), q.example("{{.RawTypeName}}"), q.filterBody(loc)),
+	}, nil
+}
 
-	payload := strings.NewReader(fmt.Sprintf(tmpl, o.config.Namespace, o.config.Key, o.config.Company, o.config.User, o.config.Password, offset, limit, nonDeletedFlag, deletedFlag, filterAttributes, filterBody))
+// ReadCommand returns the command that reads up to limit {{cleanname .TypeName}} records
+// matching q, starting at offset, for use in a Batch. A nil q matches every record.
+func (o *{{cleannamelower .TypeName}}) ReadCommand(q *Query, limit int, offset int) (Command, error) {
+	return o.readCommand(q, limit, offset, false)
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, payload)
+func (o *{{cleannamelower .TypeName}}) list(ctx context.Context, q *Query, limit int, offset int, deleted bool) ([]{{cleanname .TypeName}}, error) {
+	command, err := o.readCommand(q, limit, offset, deleted)
 	if err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
-	req.Header.Add("content-type", "application/xml")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
+
+	var r {{cleanname .TypeName}}Response
+	if err := o.api.send(ctx, command.xml, &r); err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
-	var r {{cleanname .TypeName}}Response
-	if err := xml.NewDecoder(res.Body).Decode(&r); err != nil {
+	if err := statusError("Auth", "", r.Auth.Status); err != nil {
 		return nil, err
 	}
-	if r.Auth.Status != "0" {
-		return nil, errors.New("unauthorized")
+	if err := statusError("Read", "{{.RawTypeName}}", r.Read.Status); err != nil {
+		return nil, err
 	}
 
 	if deleted {
 		for i := range r.Read.{{cleanname .TypeName}}s {
-			r.Read.{{cleanname .TypeName}}s[i].Deleted = "1"
+			r.Read.{{cleanname .TypeName}}s[i].Deleted = {{.Deleted}}
 		}
 	}
 
 	return r.Read.{{cleanname .TypeName}}s, nil
 }
 
-func (o *{{cleannamelower .TypeName}}) listWithRetry(ctx context.Context, limit int, offset int, modifiedSince *time.Time, deleted bool) ([]{{cleanname .TypeName}}, error) {
-	wait := time.Duration(o.config.RetryDelay) * time.Millisecond
-	attempt := 1
-	batch, err := o.list(ctx, limit, offset, modifiedSince, deleted)
-	if err != nil && err.Error() == "unauthorized" {
-		return nil, err
+func (o *{{cleannamelower .TypeName}}) listWithRetry(ctx context.Context, q *Query, limit int, offset int, deleted bool) ([]{{cleanname .TypeName}}, error) {
+	var batch []{{cleanname .TypeName}}
+	err := o.api.retry(ctx, func() error {
+		var err error
+		batch, err = o.list(ctx, q, limit, offset, deleted)
+		return err
+	})
+	return batch, err
+}
+
+// {{cleanname .TypeName}}Pager reads {{cleanname .TypeName}} records one page at a time.
+// Call Next until it returns false, then check Err.
+type {{cleanname .TypeName}}Pager struct {
+	o           *{{cleannamelower .TypeName}}
+	q           *Query
+	limit       int
+	concurrency int
+	offset      int
+	passes      []bool
+	pending     [][]{{cleanname .TypeName}}
+	page        []{{cleanname .TypeName}}
+	err         error
+}
+
+// Pager returns a pager over every {{cleanname .TypeName}} matching q. A nil q matches
+// every record.
+func (o *{{cleannamelower .TypeName}}) Pager(q *Query, opts ...ListOption) *{{cleanname .TypeName}}Pager {
+	return o.pager(q, ActiveRecords, opts)
+}
+
+// pager returns a pager over the records in state, unless opts choose other records
+func (o *{{cleannamelower .TypeName}}) pager(q *Query, state RecordState, opts []ListOption) *{{cleanname .TypeName}}Pager {
+	lo := newListOptions(append([]ListOption{WithRecords(state)}, opts...))
+	return &{{cleanname .TypeName}}Pager{
+		o:           o,
+		q:           q,
+		limit:       lo.pageSize,
+		concurrency: lo.concurrency,
+		passes:      lo.passes(),
+		err:         o.check(q),
 	}
-	for err != nil {
-		if attempt == 8 {
-			return nil, err
+}
+
+// Next reads the next page, and reports whether there is one. It returns
+// false when the records are exhausted, a read fails or ctx is done.
+func (p *{{cleanname .TypeName}}Pager) Next(ctx context.Context) bool {
+	p.page = nil
+	for {
+		if len(p.pending) > 0 {
+			p.page = p.pending[0]
+			p.pending = p.pending[1:]
+			return true
+		}
+		if p.err != nil || len(p.passes) == 0 {
+			return false
+		}
+		if err := ctx.Err(); err != nil {
+			p.err = err
+			return false
+		}
+		p.fetch(ctx)
+	}
+}
+
+// fetch reads the next pages of the current pass concurrently, and queues
+// them in order up to the end of the pass or the first page that failed
+func (p *{{cleanname .TypeName}}Pager) fetch(ctx context.Context) {
+	pages := make([][]{{cleanname .TypeName}}, p.concurrency)
+	errs := make([]error, p.concurrency)
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pages[i], errs[i] = p.o.listWithRetry(ctx, p.q, p.limit, p.offset+i*p.limit, p.passes[0])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, page := range pages {
+		if errs[i] != nil {
+			p.err = errs[i]
+			return
+		}
+		if len(page) > 0 {
+			p.pending = append(p.pending, page)
+		}
+		if len(page) < p.limit {
+			p.passes = p.passes[1:]
+			p.offset = 0
+			return
+		}
+	}
+	p.offset += len(pages) * p.limit
+}
+
+// Page returns the page read by the last call to Next
+func (p *{{cleanname .TypeName}}Pager) Page() []{{cleanname .TypeName}} {
+	return p.page
+}
+
+// Err returns the error that stopped Next, if any
+func (p *{{cleanname .TypeName}}Pager) Err() error {
+	return p.err
+}
+
+// ForEach calls fn with each page of {{cleanname .TypeName}} records matching q, and stops
+// at the first error from fn or from reading a page. A nil q matches every record.
+func (o *{{cleannamelower .TypeName}}) ForEach(ctx context.Context, q *Query, fn func(page []{{cleanname .TypeName}}) error, opts ...ListOption) error {
+	p := o.Pager(q, opts...)
+	for p.Next(ctx) {
+		if err := fn(p.Page()); err != nil {
+			return err
 		}
-		time.Sleep(wait)
-		wait *= 2
-		attempt += 1
-		batch, err = o.list(ctx, limit, offset, modifiedSince, deleted)
 	}
-	return batch, nil
+	return p.Err()
 }
 
-func (o *{{cleannamelower .TypeName}}) ListAsync(ctx context.Context, modifiedSince *time.Time) (<-chan []{{cleanname .TypeName}}, <-chan error) {
+// ListAsync sends pages of every {{cleanname .TypeName}}, followed by pages of deleted
+// ones unless opts choose other records, updated since modifiedSince when it
+// is set. Both channels are closed when the records are exhausted, after an
+// error is sent on the error channel, or when ctx is done, so cancel ctx to
+// stop reading early.
+func (o *{{cleannamelower .TypeName}}) ListAsync(ctx context.Context, modifiedSince *time.Time, opts ...ListOption) (<-chan []{{cleanname .TypeName}}, <-chan error) {
 	result := make(chan []{{cleanname .TypeName}})
 	errs := make(chan error, 1)
 
-	limit := 1000
+	q := NewQuery()
+	if modifiedSince != nil {
+		q.NewerThan("updated", *modifiedSince)
+	}
+	p := o.pager(q, AllRecords, opts)
 
 	go func() {
-		var err error
-		var batch []{{cleanname .TypeName}}
-		offset := 0
-		for {
-			batch, err = o.listWithRetry(ctx, limit, offset, modifiedSince, false)
-			result <- batch
-			if err != nil {
-				errs <- err
-				break
-			}
-			if len(batch) < limit {
-				break
-			}
-			offset += limit
-		}
-		if err == nil {
-			offset = 0
-			for {
-				batch, err = o.listWithRetry(ctx, limit, offset, modifiedSince, true)
-				result <- batch
-				if err != nil {
-					errs <- err
-					break
-				}
-				if len(batch) < limit {
-					break
-				}
-				offset += limit
+		defer close(errs)
+		defer close(result)
+		for p.Next(ctx) {
+			select {
+			case result <- p.Page():
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
 			}
 		}
-		close(result)
-		close(errs)
+		if err := p.Err(); err != nil {
+			errs <- err
+		}
 	}()
 
 	return result, errs
 }
 
+// List returns every {{cleanname .TypeName}} matching q, reading one page at a time.
+// A nil q matches every record.
+func (o *{{cleannamelower .TypeName}}) List(ctx context.Context, q *Query, opts ...ListOption) ([]{{cleanname .TypeName}}, error) {
+	return o.all(ctx, o.Pager(q, opts...))
+}
+
+// all reads every page from p
+func (o *{{cleannamelower .TypeName}}) all(ctx context.Context, p *{{cleanname .TypeName}}Pager) ([]{{cleanname .TypeName}}, error) {
+	var records []{{cleanname .TypeName}}
+	for p.Next(ctx) {
+		records = append(records, p.Page()...)
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+{{if and .HasID .HasUpdated}}
+// Tombstones returns a Tombstone for every deleted {{cleanname .TypeName}} matching q,
+// using its Updated time as the time it was deleted. A nil q matches every
+// deleted record.
+func (o *{{cleannamelower .TypeName}}) Tombstones(ctx context.Context, q *Query, opts ...ListOption) ([]Tombstone, error) {
+	var tombstones []Tombstone
+	err := o.ForEachTombstone(ctx, q, func(page []Tombstone) error {
+		tombstones = append(tombstones, page...)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// ForEachTombstone calls fn with the Tombstones of each page of deleted
+// {{cleanname .TypeName}} records matching q, and stops at the first error from fn or
+// from reading a page. A nil q matches every deleted record.
+func (o *{{cleannamelower .TypeName}}) ForEachTombstone(ctx context.Context, q *Query, fn func(page []Tombstone) error, opts ...ListOption) error {
+	loc, err := time.LoadLocation(o.api.config.Timezone)
+	if err != nil {
+		return err
+	}
+	p := o.Pager(q, append(opts, WithRecords(DeletedRecords))...)
+	for p.Next(ctx) {
+		records := p.Page()
+		tombstones := make([]Tombstone, len(records))
+		for i := range records {
+			tombstones[i] = Tombstone{Type: "{{.RawTypeName}}", ID: fmt.Sprint(records[i].ID)}
+			if deleted, err := records[i].Updated.in(loc); err == nil {
+				tombstones[i].Deleted = deleted
+			}
+		}
+		if err := fn(tombstones); err != nil {
+			return err
+		}
+	}
+	return p.Err()
+}
+{{end}}{{if .HasUpdated}}
+// Sync returns every {{cleanname .TypeName}} updated since since, including
+// deleted records unless opts choose other records, and the latest Updated
+// time among them. Pass that time as since to the next Sync to read only what
+// changed in between. A zero since reads every record.
+//
+// Updated times only have one second resolution, so each Sync reads from a
+// second before since, to include records updated later in the same second
+// as the last Sync read. Records updated in that second are read again, so
+// dedupe them by ID.
+func (o *{{cleannamelower .TypeName}}) Sync(ctx context.Context, since time.Time, opts ...ListOption) ([]{{cleanname .TypeName}}, time.Time, error) {
+	loc, err := time.LoadLocation(o.api.config.Timezone)
+	if err != nil {
+		return nil, since, err
+	}
+	q := NewQuery()
+	if !since.IsZero() {
+		q.NewerThan({{cleanname .TypeName}}Fields.Updated, since.Add(-time.Second))
+	}
+
+	records, err := o.all(ctx, o.pager(q, AllRecords, opts))
+	if err != nil {
+		return nil, since, err
+	}
+
+	mark := since
+	for i := range records {
+		updated, err := records[i].Updated.in(loc)
+		if err != nil {
+			continue
+		}
+		if updated.After(mark) {
+			mark = updated
+		}
+	}
+	return records, mark, nil
+}
+{{end}}
+// AddCommand returns the command that creates v, for use in a Batch. Fields
+// of v with zero values are left out, so OpenAir uses its defaults for them,
+// unless they are listed in fields.
+func (o *{{cleannamelower .TypeName}}) AddCommand(v *{{cleanname .TypeName}}, fields ...Field) (Command, error) {
+	record, err := encodeRecord("{{.RawTypeName}}", v, nil, fields...)
+	if err != nil {
+		return Command{}, err
+	}
+	return Command{
+		name:     "Add",
+		datatype: "{{.RawTypeName}}",
+		xml:      {{backtick}}<Add type="{{.RawTypeName}}" enable_custom="1">{{backtick}} + record + {{backtick}}</Add>{{backtick}},
+	}, nil
+}
+
+// Add creates v in OpenAir and returns the created {{cleanname .TypeName}}, including its new ID.
+// Fields of v with zero values are left out, so OpenAir uses its defaults for
+// them, unless they are listed in fields, e.g. to send a false flag or a zero
+// amount.
+func (o *{{cleannamelower .TypeName}}) Add(ctx context.Context, v *{{cleanname .TypeName}}, fields ...Field) (*{{cleanname .TypeName}}, error) {
+	command, err := o.AddCommand(v, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return o.one(ctx, command)
+}
+{{if .HasID}}
+// ModifyCommand returns the command that updates the given fields of the
+// {{cleanname .TypeName}} identified by v.ID, for use in a Batch
+func (o *{{cleannamelower .TypeName}}) ModifyCommand(v *{{cleanname .TypeName}}, fields ...Field) (Command, error) {
+	if v.ID == {{zero .IDType}} {
+		return Command{}, errors.New("openair: Modify {{.RawTypeName}} requires an ID")
+	}
+	if len(fields) == 0 {
+		return Command{}, errors.New("openair: Modify {{.RawTypeName}} requires at least one field")
+	}
+	only := map[Field]bool{ {{cleanname .TypeName}}Fields.ID: true}
+	for _, f := range fields {
+		only[f] = true
+	}
+	record, err := encodeRecord("{{.RawTypeName}}", v, only)
+	if err != nil {
+		return Command{}, err
+	}
+	return Command{
+		name:     "Modify",
+		datatype: "{{.RawTypeName}}",
+		xml:      {{backtick}}<Modify type="{{.RawTypeName}}" enable_custom="1">{{backtick}} + record + {{backtick}}</Modify>{{backtick}},
+	}, nil
+}
+
+// Modify updates the given fields of the {{cleanname .TypeName}} identified by v.ID.
+// Only those fields are sent, so a field that is listed but empty is cleared
+// and every other field keeps its value in OpenAir.
+func (o *{{cleannamelower .TypeName}}) Modify(ctx context.Context, v *{{cleanname .TypeName}}, fields ...Field) (*{{cleanname .TypeName}}, error) {
+	command, err := o.ModifyCommand(v, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return o.one(ctx, command)
+}
+
+// DeleteCommand returns the command that deletes the {{cleanname .TypeName}} with the
+// given id, for use in a Batch
+func (o *{{cleannamelower .TypeName}}) DeleteCommand(id {{.IDType}}) (Command, error) {
+	if id == {{zero .IDType}} {
+		return Command{}, errors.New("openair: Delete {{.RawTypeName}} requires an ID")
+	}
+	return Command{
+		name:     "Delete",
+		datatype: "{{.RawTypeName}}",
+		xml:      fmt.Sprintf({{backtick}}<Delete type="{{.RawTypeName}}"><{{.RawTypeName}}><id>%s</id></{{.RawTypeName}}></Delete>{{backtick}}, escape(fmt.Sprint(id))),
+	}, nil
+}
+
+// Delete deletes the {{cleanname .TypeName}} with the given id. Use IsNotFound,
+// IsPermissionDenied and IsDependentRecords to tell apart why it failed.
+func (o *{{cleannamelower .TypeName}}) Delete(ctx context.Context, id {{.IDType}}) error {
+	command, err := o.DeleteCommand(id)
+	if err != nil {
+		return err
+	}
+	results, err := o.api.do(ctx, command)
+	if err != nil {
+		return err
+	}
+	return results[0].Err
+}
+{{end}}
+// one sends command on its own and returns the {{cleanname .TypeName}} it responds with
+func (o *{{cleannamelower .TypeName}}) one(ctx context.Context, command Command) (*{{cleanname .TypeName}}, error) {
+	results, err := o.api.do(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	if results[0].Err != nil {
+		return nil, results[0].Err
+	}
+	var records []{{cleanname .TypeName}}
+	if err := results[0].Decode(&records); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("openair: %s {{.RawTypeName}} returned no record", command.name)
+	}
+	return &records[0], nil
+}
 `))
 
+var typesTmpl = template.Must(template.New("types").Funcs(template.FuncMap{
+	"fieldtag": fieldtag,
+	"join":     strings.Join,
+}).Parse(`
+// Code generated by openair; DO NOT EDIT.
+
+package {{.PackageName}}
+{{range .Types}}
+// {{.Name}} is the {{.RawName}} element nested in {{join .Datatypes ", "}}
+type {{.Name}} struct {
+	{{range .Fields}}{{.FieldName}} {{.FieldType}} {{fieldtag .}}{{with .Comment}} // {{.}}{{end}}
+	{{end}}
+}
+{{end}}`))
+
 var commonTmpl = template.Must(template.New("common").Funcs(template.FuncMap{
 	"tag":            tag,
 	"xmltag":         xmltag,
@@ -281,13 +649,35 @@ package {{.PackageName}}
 import (
 	"github.com/kelseyhightower/envconfig"
 
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // API is an OpenAir XML API client.
 type API struct {
-	config *Config
+	config     *Config
+	httpClient *http.Client
+
+	// mu guards sessionID, which is shared by every datatype
+	mu        sync.Mutex
+	sessionID string
+
+	// limiter and inFlight throttle requests from every datatype; each is nil when unlimited
+	limiter  *limiter
+	inFlight chan struct{}
+
 	{{range $idx, $value := .Types}}{{cleanname $value}} *{{cleannamelower $value}}
 {{end}}
 }
@@ -302,33 +692,157 @@ type Config struct {
 	User       string {{backtick}}required:"true"{{backtick}}
 	Password   string {{backtick}}required:"true"{{backtick}}
 	RetryDelay int    {{backtick}}default:"100"{{backtick}}
+	Retry      RetryPolicy
+	// Timezone is the OpenAir company's timezone, which OpenAir reads and writes dates in
+	Timezone   string {{backtick}}default:"UTC"{{backtick}}
+	// UseSession logs in once and authenticates later requests with the
+	// session id, instead of sending the password with every request
+	UseSession bool   {{backtick}}default:"false"{{backtick}}
+	// RequestsPerSecond limits the rate of requests, in bursts of up to
+	// Burst requests; zero means no limit
+	RequestsPerSecond float64 {{backtick}}default:"0"{{backtick}}
+	Burst             int     {{backtick}}default:"1"{{backtick}}
+	// MaxInFlight limits how many requests are sent at once; zero means no limit
+	MaxInFlight int {{backtick}}default:"0"{{backtick}}
+}
+
+// Option customizes an API created by New or NewWithConfig
+type Option func(*API)
+
+// WithHTTPClient sends every request through client instead of
+// http.DefaultClient. A nil client keeps http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(api *API) {
+		if client == nil {
+			client = http.DefaultClient
+		}
+		api.httpClient = client
+	}
+}
+
+// WithRoundTripper sends every request through rt, e.g. to record requests in tests
+func WithRoundTripper(rt http.RoundTripper) Option {
+	return func(api *API) {
+		client := *api.httpClient
+		client.Transport = rt
+		api.httpClient = &client
+	}
+}
+
+// RetryPolicy controls how reads that fail are retried. Waits start at
+// Config.RetryDelay milliseconds and double after each attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first
+	MaxAttempts int           {{backtick}}default:"8"{{backtick}}
+	// MaxDelay caps the wait between attempts; zero means no cap
+	MaxDelay    time.Duration {{backtick}}default:"30s"{{backtick}}
+	// Jitter randomizes each wait by up to this fraction of it, e.g. 0.2 for ±20%
+	Jitter      float64       {{backtick}}default:"0.2"{{backtick}}
+	// Retryable reports whether a request that failed with err should be
+	// retried; DefaultRetryable is used when it is nil
+	Retryable   func(err error) bool {{backtick}}ignored:"true"{{backtick}}
+	// OnRetry, when set, is called before waiting to retry a failed attempt
+	OnRetry     func(attempt int, err error, wait time.Duration) {{backtick}}ignored:"true"{{backtick}}
+}
+
+// DefaultRetryable retries rate limits, unknown OpenAir errors, HTTP 429 and
+// 5xx responses and transport errors, but not other OpenAir or HTTP errors,
+// responses that can't be decoded or cancellation
+func DefaultRetryable(err error) bool {
+	switch e := err.(type) {
+	case *Error:
+		return IsRateLimited(e) || e.Code == StatusUnknown
+	case *HTTPError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case *DecodeError, *xml.SyntaxError:
+		return false
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// delay returns the wait before the next attempt, given the un-jittered wait
+func (p *RetryPolicy) delay(wait time.Duration) time.Duration {
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	if p.MaxDelay > 0 && wait > p.MaxDelay {
+		wait = p.MaxDelay
+	}
+	return wait
+}
+
+// retry calls f until it succeeds, fails with an error the retry policy
+// doesn't retry, runs out of attempts or ctx is done
+func (api *API) retry(ctx context.Context, f func() error) error {
+	p := api.config.Retry
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 8
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	wait := time.Duration(api.config.RetryDelay) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		d := p.delay(wait)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, d)
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		wait *= 2
+		if p.MaxDelay > 0 && wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+	}
 }
 
 // New creates a new OpenAir API, making use of the environment to generate a Config
-func New() (*API, error) {
+func New(opts ...Option) (*API, error) {
 	var c Config
 	err := envconfig.Process("openair", &c)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewWithConfig(&c), nil
+	return NewWithConfig(&c, opts...), nil
 }
 
 // NewWithConfig creates a new OpenAir API with the provided Config
-func NewWithConfig(c *Config) *API {
+func NewWithConfig(c *Config, opts ...Option) *API {
 	api := &API{
-	config: c,
-	{{range $idx, $value := .Types}}{{cleanname $value}}: &{{cleannamelower $value}}{ config: c, },
-	{{end}}
+		config:     c,
+		httpClient: http.DefaultClient,
 	}
-
+	if c.RequestsPerSecond > 0 {
+		api.limiter = newLimiter(c.RequestsPerSecond, c.Burst)
+	}
+	if c.MaxInFlight > 0 {
+		api.inFlight = make(chan struct{}, c.MaxInFlight)
+	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	{{range $idx, $value := .Types}}api.{{cleanname $value}} = &{{cleannamelower $value}}{api: api}
+	{{end}}
 	return api
 }
 
 // Auth includes status information about the authorization of a request
 type Auth struct {
-	Status string {{xmltag "status,attr"}}
+	Status  string {{xmltag "status,attr"}}
+	Session string {{xmltag "Session,omitempty"}}
 }
 
 // Date is a date
@@ -364,6 +878,73 @@ func (d *Date) ToUTC(timezone string) (*time.Time, error) {
 	return &result, nil
 }
 
+// in returns the time d represents, interpreted in loc
+func (d *Date) in(loc *time.Location) (time.Time, error) {
+	parts := []string{d.Year, d.Month, d.Day, d.Hour, d.Minute, d.Second}
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		if part == "" && i >= 3 {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("openair: invalid date %+v", *d)
+		}
+		values[i] = v
+	}
+	return time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0, loc), nil
+}
+
+// Time is a Date decoded as a time.Time. OpenAir dates carry no timezone,
+// so the wall clock time is kept as is, in UTC; use the company's Timezone
+// to place it.
+type Time struct {
+	time.Time
+}
+
+// MarshalXML encodes t as a Date. A zero t encodes as nothing, which clears
+// the field in a Modify.
+func (t Time) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if t.IsZero() {
+		return nil
+	}
+	d := Date{
+		Year:   strconv.Itoa(t.Year()),
+		Month:  strconv.Itoa(int(t.Month())),
+		Day:    strconv.Itoa(t.Day()),
+		Hour:   strconv.Itoa(t.Hour()),
+		Minute: strconv.Itoa(t.Minute()),
+		Second: strconv.Itoa(t.Second()),
+	}
+	return e.EncodeElement(d, start)
+}
+
+// UnmarshalXML decodes a Date into t. An empty Date leaves t zero.
+func (t *Time) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var date Date
+	if err := d.DecodeElement(&date, &start); err != nil {
+		return err
+	}
+	if date.Year == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := date.in(time.UTC)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// in returns the wall clock time of t, interpreted in loc
+func (t Time) in(loc *time.Location) (time.Time, error) {
+	if t.IsZero() {
+		return time.Time{}, errors.New("openair: empty date")
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+}
+
 // Address is an address
 type Address struct {
 	ID         string {{tag "id" "string"}}
@@ -385,49 +966,1117 @@ type Address struct {
 	Zip        string {{tag "zip" "string"}}
 	Country    string {{tag "country" "string"}}
 }
-`))
 
-var commonTestTmpl = template.Must(template.New("common_test").Funcs(template.FuncMap{}).Parse(`
-// Code generated by openair; DO NOT EDIT.
+// defaultPageSize is the number of records read per request when paging
+const defaultPageSize = 1000
 
-package {{.PackageName}}
+// ListOption customizes how records are paged
+type ListOption func(*listOptions)
 
-import (
-	"testing"
-	"time"
+type listOptions struct {
+	pageSize    int
+	concurrency int
+	state       RecordState
+}
+
+// RecordState selects active records, deleted records or both
+type RecordState int
+
+// Record states for WithRecords
+const (
+	ActiveRecords RecordState = iota
+	DeletedRecords
+	AllRecords
 )
 
-func TestNewWithConfig(t *testing.T) {
-	c := &Config{}
-	api := NewWithConfig(c)
-	if api == nil {
-		t.Error("expected non-nil API, but got nil API")
+// WithRecords reads the records in state. Pager, List and ForEach read
+// ActiveRecords by default; ListAsync and Sync read AllRecords.
+func WithRecords(state RecordState) ListOption {
+	return func(o *listOptions) {
+		o.state = state
 	}
 }
 
-func TestToDate(t *testing.T) {
-	d := Date{
-		Year:   "2017",
-		Month:  "01",
-		Day:    "31",
-		Hour:   "10",
-		Minute: "26",
-		Second: "59",
+// passes returns the deleted flag of each read needed for the record state
+func (o listOptions) passes() []bool {
+	switch o.state {
+	case DeletedRecords:
+		return []bool{true}
+	case AllRecords:
+		return []bool{false, true}
 	}
-	timezone := "America/New_York"
-	loc, err := time.LoadLocation(timezone)
-	if err != nil {
-		t.Fail()
+	return []bool{false}
+}
+
+// WithPageSize reads n records per request instead of 1000
+func WithPageSize(n int) ListOption {
+	return func(o *listOptions) {
+		o.pageSize = n
 	}
-	now := time.Now().In(loc)
-	tz := now.Format("Z07:00")
-	c, err := d.ToUTC(timezone)
-	if err != nil {
-		t.Error(err)
+}
+
+// WithConcurrency reads n pages at once. Pages are still returned in order.
+func WithConcurrency(n int) ListOption {
+	return func(o *listOptions) {
+		o.concurrency = n
 	}
-	if c == nil {
-		t.Error("expected a non-nil date")
-		return
+}
+
+func newListOptions(opts []ListOption) listOptions {
+	o := listOptions{pageSize: defaultPageSize, concurrency: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.pageSize < 1 {
+		o.pageSize = defaultPageSize
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	return o
+}
+
+// Tombstone records that a record was deleted
+type Tombstone struct {
+	Type    string
+	ID      string
+	Deleted time.Time
+}
+
+// Field identifies a field of an OpenAir datatype by its XML name
+type Field string
+
+// Error is returned when OpenAir reports a non-zero status for a command.
+// Command is "Auth" when the request could not be authenticated.
+type Error struct {
+	Code    int
+	Command string
+	Type    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	command := e.Command
+	if e.Type != "" {
+		command += " " + e.Type
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("openair: %s failed with status %d", command, e.Code)
+	}
+	return fmt.Sprintf("openair: %s failed with status %d: %s", command, e.Code, e.Message)
+}
+
+// HTTPError is returned when OpenAir, or a proxy in front of it, responds
+// with an HTTP status other than 2xx
+type HTTPError struct {
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("openair: request failed with HTTP status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// DecodeError is returned when a response can't be decoded, e.g. because it
+// isn't XML or a value doesn't fit the type of its field
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "openair: decoding response: " + e.Err.Error()
+}
+
+// decode unmarshals the response body into v
+func decode(body []byte, v interface{}) error {
+	if err := xml.Unmarshal(body, v); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+// Status codes OpenAir returns for failed commands
+const (
+	StatusUnknown          = 1
+	StatusAuthFailed       = 401
+	StatusInvalidKey       = 402
+	StatusSessionExpired   = 404
+	StatusPermissionDenied = 416
+	StatusRequestLimit     = 555
+	StatusRateLimit        = 556
+	StatusNotFound         = 601
+	StatusInvalidField     = 602
+	StatusInvalidType      = 603
+	StatusDependentRecords = 1001
+)
+
+// errorMessages describes the status codes documented in the OpenAir XML API guide
+var errorMessages = map[int]string{
+	StatusUnknown:          "unknown error",
+	StatusAuthFailed:       "authentication failed, check the company, user and password",
+	StatusInvalidKey:       "invalid API key or namespace",
+	StatusSessionExpired:   "session is invalid or has expired",
+	StatusPermissionDenied: "permission denied",
+	StatusRequestLimit:     "request limit exceeded for the account",
+	StatusRateLimit:        "too many requests, slow down",
+	StatusNotFound:         "no record with the given id",
+	StatusInvalidField:     "invalid field",
+	StatusInvalidType:      "invalid datatype or method",
+	StatusDependentRecords: "other records depend on this record",
+}
+
+func hasCode(err error, codes ...int) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAuthError reports whether err is an *Error for a request OpenAir could not authenticate
+func IsAuthError(err error) bool {
+	e, ok := err.(*Error)
+	return ok && (e.Command == "Auth" || hasCode(err, StatusAuthFailed, StatusInvalidKey, StatusSessionExpired))
+}
+
+// IsRateLimited reports whether err is an *Error for a request OpenAir refused due to rate limits
+func IsRateLimited(err error) bool {
+	return hasCode(err, StatusRequestLimit, StatusRateLimit)
+}
+
+// IsNotFound reports whether err is an *Error for a record that doesn't exist
+func IsNotFound(err error) bool {
+	return hasCode(err, StatusNotFound)
+}
+
+// IsPermissionDenied reports whether err is an *Error for a command the user is not permitted to run
+func IsPermissionDenied(err error) bool {
+	return hasCode(err, StatusPermissionDenied)
+}
+
+// IsDependentRecords reports whether err is an *Error for a record that other records still refer to
+func IsDependentRecords(err error) bool {
+	return hasCode(err, StatusDependentRecords)
+}
+
+// statusError returns an *Error for a non-zero command status, or nil
+func statusError(command string, datatype string, status string) error {
+	if status == "0" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("openair: %s %s returned invalid status %q", command, datatype, status)
+	}
+	return &Error{Code: code, Command: command, Type: datatype, Message: errorMessages[code]}
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// login returns the Login element that authenticates with the company, user and password
+func (c *Config) login() string {
+	return fmt.Sprintf("<Login><company>%s</company><user>%s</user><password>%s</password></Login>",
+		escape(c.Company), escape(c.User), escape(c.Password))
+}
+
+// envelope wraps commands in a request that authenticates with auth
+func (c *Config) envelope(auth string, commands string) string {
+	tmpl := {{backtick}}<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+		<request API_version="1.0" client_ver="1.1" namespace="%s" key="%s">
+			<Auth>%s</Auth>
+			%s
+		</request>{{backtick}}
+
+	return fmt.Sprintf(tmpl, escape(c.Namespace), escape(c.Key), auth, commands)
+}
+
+// limiter is a token bucket that allows rate requests per second, in bursts of up to burst
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a request may be sent or ctx is done
+func (l *limiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// throttle waits until a request may be sent under the API's limits, and
+// returns the func to call once the request is done
+func (api *API) throttle(ctx context.Context) (func(), error) {
+	if api.limiter != nil {
+		if err := api.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if api.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case api.inFlight <- struct{}{}:
+		return func() { <-api.inFlight }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// post sends request to OpenAir and returns the response body
+func (api *API) post(ctx context.Context, request string) ([]byte, error) {
+	done, err := api.throttle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	url := fmt.Sprintf("%s://%s/api.pl", api.config.Scheme, api.config.Domain)
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("content-type", "application/xml")
+	res, err := api.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil, &HTTPError{StatusCode: res.StatusCode}
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// session returns the current session id, logging in first if there isn't one
+func (api *API) session(ctx context.Context) (string, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.sessionID != "" {
+		return api.sessionID, nil
+	}
+
+	body, err := api.post(ctx, api.config.envelope(api.config.login(), ""))
+	if err != nil {
+		return "", err
+	}
+	var r struct {
+		Auth Auth {{xmltag "Auth"}}
+	}
+	if err := decode(body, &r); err != nil {
+		return "", err
+	}
+	if err := statusError("Auth", "", r.Auth.Status); err != nil {
+		return "", err
+	}
+	if r.Auth.Session == "" {
+		return "", errors.New("openair: login did not return a session")
+	}
+	api.sessionID = r.Auth.Session
+	return api.sessionID, nil
+}
+
+// expire forgets the session id, unless another request already replaced it
+func (api *API) expire(id string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.sessionID == id {
+		api.sessionID = ""
+	}
+}
+
+// send posts commands to OpenAir and decodes the response into v. With
+// UseSession, an expired session is replaced and the commands are sent again.
+func (api *API) send(ctx context.Context, commands string, v interface{}) error {
+	if !api.config.UseSession {
+		body, err := api.post(ctx, api.config.envelope(api.config.login(), commands))
+		if err != nil {
+			return err
+		}
+		return decode(body, v)
+	}
+
+	for attempt := 1; ; attempt++ {
+		id, err := api.session(ctx)
+		if err != nil {
+			return err
+		}
+		body, err := api.post(ctx, api.config.envelope("<Session>"+escape(id)+"</Session>", commands))
+		if err != nil {
+			return err
+		}
+		var r struct {
+			Auth Auth {{xmltag "Auth"}}
+		}
+		if err := decode(body, &r); err != nil {
+			return err
+		}
+		if attempt == 1 && hasCode(statusError("Auth", "", r.Auth.Status), StatusSessionExpired) {
+			api.expire(id)
+			continue
+		}
+		return decode(body, v)
+	}
+}
+
+// Logout ends the current session, if there is one. The next request logs in
+// again.
+func (api *API) Logout(ctx context.Context) error {
+	api.mu.Lock()
+	id := api.sessionID
+	api.sessionID = ""
+	api.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+
+	body, err := api.post(ctx, api.config.envelope("<Session>"+escape(id)+"</Session>", "<Logout/>"))
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Auth Auth {{xmltag "Auth"}}
+	}
+	if err := decode(body, &r); err != nil {
+		return err
+	}
+	return statusError("Auth", "", r.Auth.Status)
+}
+
+// Command is a single OpenAir XML API command, such as an Add or a Read, that
+// can be sent on its own or queued in a Batch
+type Command struct {
+	name     string
+	datatype string
+	xml      string
+}
+
+// Result is the outcome of a Command
+type Result struct {
+	Command string
+	Type    string
+	Status  string
+	// Err is an *Error when Status is not 0
+	Err     error
+	records []byte
+}
+
+// Decode appends the records returned by the command to v, which must be a
+// pointer to a slice of the command's datatype, such as *[]Customer
+func (r *Result) Decode(v interface{}) error {
+	slice := reflect.ValueOf(v)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("openair: Decode requires a pointer to a slice, got %T", v)
+	}
+	slice = slice.Elem()
+	d := xml.NewDecoder(bytes.NewReader(r.records))
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &DecodeError{Err: err}
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != r.Type {
+			continue
+		}
+		record := reflect.New(slice.Type().Elem())
+		if err := d.DecodeElement(record.Interface(), &start); err != nil {
+			return &DecodeError{Err: err}
+		}
+		slice.Set(reflect.Append(slice, record.Elem()))
+	}
+}
+
+// Query selects the records a Read returns
+type Query struct {
+	method  string
+	fields  []Field
+	values  []string
+	filters []dateFilter
+	err     error
+}
+
+type dateFilter struct {
+	name  string
+	field Field
+	t     time.Time
+}
+
+// NewQuery creates a Query that matches every record
+func NewQuery() *Query {
+	return &Query{method: "all"}
+}
+
+// Where matches records whose field equals value. Several Where calls match
+// records that equal all of the values.
+func (q *Query) Where(f Field, value string) *Query {
+	return q.match("equal to", f, value)
+}
+
+// WhereNot matches records whose field does not equal value. A query can't
+// combine Where and WhereNot, since OpenAir only allows one method per Read.
+func (q *Query) WhereNot(f Field, value string) *Query {
+	return q.match("not equal to", f, value)
+}
+
+func (q *Query) match(method string, f Field, value string) *Query {
+	if q.method != "all" && q.method != method {
+		q.err = fmt.Errorf("openair: a query can't combine %q and %q", q.method, method)
+	}
+	q.method = method
+	q.fields = append(q.fields, f)
+	q.values = append(q.values, value)
+	return q
+}
+
+// NewerThan matches records whose Date field f is after t
+func (q *Query) NewerThan(f Field, t time.Time) *Query {
+	return q.filter("newer-than", f, t)
+}
+
+// OlderThan matches records whose Date field f is before t
+func (q *Query) OlderThan(f Field, t time.Time) *Query {
+	return q.filter("older-than", f, t)
+}
+
+// DateEqualTo matches records whose Date field f is on the same day as t
+func (q *Query) DateEqualTo(f Field, t time.Time) *Query {
+	return q.filter("date-equal-to", f, t)
+}
+
+// Between matches records whose Date field f is after from and before to
+func (q *Query) Between(f Field, from time.Time, to time.Time) *Query {
+	return q.NewerThan(f, from).OlderThan(f, to)
+}
+
+func (q *Query) filter(name string, f Field, t time.Time) *Query {
+	q.filters = append(q.filters, dateFilter{name: name, field: f, t: t})
+	return q
+}
+
+func (q *Query) filterAttributes() string {
+	if len(q.filters) == 0 {
+		return ""
+	}
+	names := make([]string, len(q.filters))
+	fields := make([]string, len(q.filters))
+	for i, f := range q.filters {
+		names[i] = f.name
+		fields[i] = string(f.field)
+	}
+	return fmt.Sprintf(" filter=\"%s\" field=\"%s\"", strings.Join(names, ","), strings.Join(fields, ","))
+}
+
+// filterBody returns the Date elements for the filters, in loc
+func (q *Query) filterBody(loc *time.Location) string {
+	var buf bytes.Buffer
+	for _, f := range q.filters {
+		t := f.t.In(loc)
+		fmt.Fprintf(&buf, "<Date><year>%d</year><month>%d</month><day>%d</day><hour>%d</hour><minute>%d</minute><second>%d</second></Date>",
+			t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	}
+	return buf.String()
+}
+
+// example returns the datatype element OpenAir compares records against
+func (q *Query) example(datatype string) string {
+	if len(q.fields) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<" + datatype + ">")
+	for i, f := range q.fields {
+		buf.WriteString("<" + string(f) + ">" + escape(q.values[i]) + "</" + string(f) + ">")
+	}
+	buf.WriteString("</" + datatype + ">")
+	return buf.String()
+}
+
+// Batch queues commands, for any datatypes, to send to OpenAir in a single request
+type Batch struct {
+	api      *API
+	commands []Command
+}
+
+// NewBatch creates an empty Batch
+func (api *API) NewBatch() *Batch {
+	return &Batch{api: api}
+}
+
+// Queue adds commands to the end of the batch
+func (b *Batch) Queue(commands ...Command) *Batch {
+	b.commands = append(b.commands, commands...)
+	return b
+}
+
+// Send sends the queued commands in a single request and returns their
+// results in the order they were queued
+func (b *Batch) Send(ctx context.Context) ([]Result, error) {
+	return b.api.do(ctx, b.commands...)
+}
+
+type commandResponse struct {
+	XMLName xml.Name
+	Status  string {{xmltag "status,attr"}}
+	Records []byte {{xmltag ",innerxml"}}
+}
+
+// do sends commands in a single request and returns their results in order
+func (api *API) do(ctx context.Context, commands ...Command) ([]Result, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	var body bytes.Buffer
+	for _, command := range commands {
+		body.WriteString(command.xml)
+	}
+
+	var r struct {
+		XMLName  xml.Name          {{xmltag "response"}}
+		Auth     Auth              {{xmltag "Auth"}}
+		Commands []commandResponse {{xmltag ",any"}}
+	}
+	if err := api.send(ctx, body.String(), &r); err != nil {
+		return nil, err
+	}
+	if err := statusError("Auth", "", r.Auth.Status); err != nil {
+		return nil, err
+	}
+	if len(r.Commands) != len(commands) {
+		return nil, fmt.Errorf("openair: sent %d commands but got %d results", len(commands), len(r.Commands))
+	}
+
+	results := make([]Result, len(commands))
+	for i, command := range commands {
+		results[i] = Result{
+			Command: command.name,
+			Type:    command.datatype,
+			Status:  r.Commands[i].Status,
+			Err:     statusError(command.name, command.datatype, r.Commands[i].Status),
+			records: r.Commands[i].Records,
+		}
+	}
+	return results, nil
+}
+
+// encodeRecord encodes v as a datatype element for an Add or Modify command.
+// When only is nil, fields with zero values are left out so OpenAir keeps
+// its defaults for them, except for those listed in zero. Otherwise exactly
+// the fields named in only are encoded, including zero values, which clear
+// the field.
+func encodeRecord(datatype string, v interface{}, only map[Field]bool, zero ...Field) (string, error) {
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	start := xml.StartElement{Name: xml.Name{Local: datatype}}
+	if err := e.EncodeToken(start); err != nil {
+		return "", err
+	}
+
+	always := map[Field]bool{}
+	for _, f := range zero {
+		always[f] = true
+	}
+	if err := encodeFields(e, reflect.Indirect(reflect.ValueOf(v)), only, always); err != nil {
+		return "", err
+	}
+
+	if err := e.EncodeToken(start.End()); err != nil {
+		return "", err
+	}
+	if err := e.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// marshalerType is the type of xml.Marshaler
+var marshalerType = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+
+// isNested reports whether t is a struct whose fields encodeFields encodes,
+// such as a Date or a nested element, rather than one that encodes itself
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !t.Implements(marshalerType) && !reflect.PtrTo(t).Implements(marshalerType)
+}
+
+// encodeFields encodes the fields of the struct rv for encodeRecord,
+// including those of embedded structs such as custom fields and those of
+// nested elements
+func encodeFields(e *xml.Encoder, rv reflect.Value, only map[Field]bool, always map[Field]bool) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rv.Field(i)
+		if rt.Field(i).Anonymous && f.Kind() == reflect.Struct {
+			if err := encodeFields(e, f, only, always); err != nil {
+				return err
+			}
+			continue
+		}
+		path := strings.Split(strings.Split(rt.Field(i).Tag.Get("xml"), ",")[0], ">")
+		if only != nil && !only[Field(path[0])] {
+			continue
+		}
+		if only == nil && !always[Field(path[0])] && reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface()) {
+			continue
+		}
+		value := f.Interface()
+		switch f.Kind() {
+		case reflect.Bool:
+			// OpenAir flags are 0 or 1, not true or false
+			value = 0
+			if f.Bool() {
+				value = 1
+			}
+		case reflect.Float32, reflect.Float64:
+			// OpenAir doesn't accept exponents, e.g. 1e+06
+			value = strconv.FormatFloat(f.Float(), 'f', -1, 64)
+		}
+		last := len(path) - 1
+		for _, name := range path[:last] {
+			if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+				return err
+			}
+		}
+		if err := encodeValue(e, f, value, xml.StartElement{Name: xml.Name{Local: path[last]}}); err != nil {
+			return err
+		}
+		for j := last - 1; j >= 0; j-- {
+			if err := e.EncodeToken(xml.EndElement{Name: xml.Name{Local: path[j]}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeValue encodes the field f as start, encoding nested elements, and
+// each element of a slice of them, with encodeFields
+func encodeValue(e *xml.Encoder, f reflect.Value, value interface{}, start xml.StartElement) error {
+	switch {
+	case isNested(f.Type()):
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := encodeFields(e, f, nil, nil); err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	case f.Kind() == reflect.Slice && isNested(f.Type().Elem()):
+		for i := 0; i < f.Len(); i++ {
+			if err := encodeValue(e, f.Index(i), nil, start); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.EncodeElement(value, start)
+}
+`))
+
+var commonTestTmpl = template.Must(template.New("common_test").Funcs(template.FuncMap{
+	"tag":       tag,
+	"backtick":  backtick,
+	"xmltag":    xmltag,
+	"xmlrawtag": xmlrawtag,
+}).Parse(`
+// Code generated by openair; DO NOT EDIT.
+
+package {{.PackageName}}
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewWithConfig(t *testing.T) {
+	c := &Config{}
+	api := NewWithConfig(c)
+	if api == nil {
+		t.Error("expected non-nil API, but got nil API")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithRoundTripper(t *testing.T) {
+	requests := 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		body := {{backtick}}<response><Auth status="0"/><Read status="0"></Read></response>{{backtick}}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(rt))
+	if api.httpClient == http.DefaultClient {
+		t.Error("expected WithRoundTripper not to modify http.DefaultClient")
+	}
+	results, err := api.do(context.Background(), Command{name: "Read", datatype: "Customer", xml: "<Read/>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || len(results) != 1 || results[0].Err != nil {
+		t.Errorf("expected one successful request, got %d requests and results %+v", requests, results)
+	}
+}
+
+func TestSession(t *testing.T) {
+	var requests []string
+	logins := 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		request := string(b)
+		requests = append(requests, request)
+		body := {{backtick}}<response><Auth status="0"/><Read status="0"></Read></response>{{backtick}}
+		switch {
+		case strings.Contains(request, "<Login>"):
+			logins++
+			body = fmt.Sprintf({{backtick}}<response><Auth status="0"><Session>s%d</Session></Auth></response>{{backtick}}, logins)
+		case strings.Contains(request, "<Session>s1</Session>"):
+			body = {{backtick}}<response><Auth status="404"/></response>{{backtick}}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com", Password: "secret", UseSession: true}, WithRoundTripper(rt))
+	command := Command{name: "Read", datatype: "Customer", xml: "<Read/>"}
+
+	if _, err := api.do(context.Background(), command); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.do(context.Background(), command); err != nil {
+		t.Fatal(err)
+	}
+	if logins != 2 {
+		t.Errorf("expected to log in again after the session expired, got %d logins", logins)
+	}
+	for _, request := range requests {
+		if strings.Contains(request, "secret") && !strings.Contains(request, "<Login>") {
+			t.Errorf("expected the password only in login requests, got %v", request)
+		}
+	}
+	if len(requests) != 5 {
+		t.Errorf("expected 5 requests, got %d", len(requests))
+	}
+
+	if err := api.Logout(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(requests[len(requests)-1], "<Logout/>") {
+		t.Error("expected Logout to end the session")
+	}
+	if api.sessionID != "" {
+		t.Error("expected Logout to forget the session")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var retries []int
+	api := NewWithConfig(&Config{Retry: RetryPolicy{
+		MaxAttempts: 3,
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			retries = append(retries, attempt)
+		},
+	}})
+	calls := 0
+	err := api.retry(context.Background(), func() error {
+		calls++
+		return statusError("Read", "Customer", "556")
+	})
+	if !IsRateLimited(err) {
+		t.Errorf("expected the last error, got %v", err)
+	}
+	if calls != 3 || len(retries) != 2 {
+		t.Errorf("expected 3 attempts and 2 retries, got %d and %d", calls, len(retries))
+	}
+
+	calls = 0
+	err = api.retry(context.Background(), func() error {
+		calls++
+		return statusError("Read", "Customer", "601")
+	})
+	if calls != 1 || !IsNotFound(err) {
+		t.Errorf("expected no retries for a permanent error, got %d calls and %v", calls, err)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	api := NewWithConfig(&Config{RetryDelay: 60000, Retry: RetryPolicy{
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			cancel()
+		},
+	}})
+	err := api.retry(ctx, func() error {
+		return errors.New("connection reset")
+	})
+	if err != context.Canceled {
+		t.Errorf("expected the wait to stop when ctx is canceled, got %v", err)
+	}
+}
+
+func TestRetryHTTPStatus(t *testing.T) {
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest}
+	requests := 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		status := statuses[requests]
+		requests++
+		body := {{backtick}}<response><Auth status="0"/><Read status="0"></Read></response>{{backtick}}
+		if status != http.StatusOK {
+			body = "<html><body>unavailable</body></html>"
+		}
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com", RetryDelay: 1}, WithRoundTripper(rt))
+	command := Command{name: "Read", datatype: "Customer", xml: "<Read/>"}
+	do := func() error {
+		_, err := api.do(context.Background(), command)
+		return err
+	}
+
+	if err := api.retry(context.Background(), do); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("expected HTTP 503 and 429 to be retried, got %d requests", requests)
+	}
+
+	err := api.retry(context.Background(), do)
+	if e, ok := err.(*HTTPError); !ok || e.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an *HTTPError for status 400, got %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("expected HTTP 400 not to be retried, got %d requests", requests)
+	}
+}
+
+func TestRetryDecodeError(t *testing.T) {
+	records := []string{
+		"<Customer><rate>n/a</rate></Customer>",
+		"<Customer><updated><Date><year>2017</year><month>Feb</month><day>1</day></Date></updated></Customer>",
+		"<Customer><rate>1</rate></Customer><Customer",
+	}
+	for _, record := range records {
+		requests := 0
+		rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+			body := {{backtick}}<response><Auth status="0"/><Read status="0">{{backtick}} + record + {{backtick}}</Read></response>{{backtick}}
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		})
+		retries := 0
+		api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com", RetryDelay: 1, Retry: RetryPolicy{
+			OnRetry: func(attempt int, err error, wait time.Duration) {
+				retries++
+			},
+		}}, WithRoundTripper(rt))
+
+		var r struct {
+			Read struct {
+				Customers []struct {
+					Rate    float64 {{tag "rate" "float64"}}
+					Updated Time    {{tag "updated" "Time"}}
+				} {{xmlrawtag "Customer"}}
+			} {{xmltag "Read"}}
+		}
+		err := api.retry(context.Background(), func() error {
+			return api.send(context.Background(), "<Read/>", &r)
+		})
+		if _, ok := err.(*DecodeError); !ok {
+			t.Errorf("expected a *DecodeError for %s, got %v", record, err)
+		}
+		if requests != 1 || retries != 0 {
+			t.Errorf("expected %s not to be retried, got %d requests and %d retries", record, requests, retries)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxDelay: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := p.delay(100 * time.Millisecond)
+		if d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("expected a delay within 50%% of 100ms, got %v", d)
+		}
+	}
+	if d := p.delay(time.Minute); d != time.Second {
+		t.Errorf("expected the delay to be capped at 1s, got %v", d)
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	if DefaultRetryable(&xml.SyntaxError{Msg: "bad", Line: 1}) {
+		t.Error("expected malformed XML not to be retryable")
+	}
+	if DefaultRetryable(&DecodeError{Err: errors.New("invalid date")}) {
+		t.Error("expected a response that can't be decoded not to be retryable")
+	}
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		if !DefaultRetryable(&HTTPError{StatusCode: code}) {
+			t.Errorf("expected HTTP status %d to be retryable", code)
+		}
+	}
+	for _, code := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound} {
+		if DefaultRetryable(&HTTPError{StatusCode: code}) {
+			t.Errorf("expected HTTP status %d not to be retryable", code)
+		}
+	}
+	if DefaultRetryable(context.Canceled) {
+		t.Error("expected cancellation not to be retryable")
+	}
+	if !DefaultRetryable(errors.New("connection reset")) {
+		t.Error("expected transport errors to be retryable")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(100, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("expected 2 requests beyond the burst to wait about 20ms, waited %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newLimiter(0.001, 1).wait(ctx); err != nil {
+		t.Errorf("expected the burst to be available immediately, got %v", err)
+	}
+	l = newLimiter(0.001, 1)
+	l.wait(context.Background())
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("expected the wait to stop when ctx is canceled, got %v", err)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	current, max := 0, 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		current++
+		if current > max {
+			max = current
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		current--
+		mu.Unlock()
+		body := {{backtick}}<response><Auth status="0"/><Read status="0"></Read></response>{{backtick}}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com", MaxInFlight: 2}, WithRoundTripper(rt))
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			api.do(context.Background(), Command{name: "Read", datatype: "Customer", xml: "<Read/>"})
+		}()
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", max)
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	api := NewWithConfig(&Config{}, WithHTTPClient(client))
+	if api.httpClient != client {
+		t.Error("expected the API to use the provided client")
+	}
+
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("unused")
+	})
+	api = NewWithConfig(&Config{}, WithHTTPClient(nil), WithRoundTripper(rt))
+	if api.httpClient == http.DefaultClient || api.httpClient.Transport == nil {
+		t.Error("expected a nil client to fall back to a copy of http.DefaultClient using rt")
+	}
+}
+
+func TestToDate(t *testing.T) {
+	d := Date{
+		Year:   "2017",
+		Month:  "01",
+		Day:    "31",
+		Hour:   "10",
+		Minute: "26",
+		Second: "59",
+	}
+	timezone := "America/New_York"
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		t.Fail()
+	}
+	now := time.Now().In(loc)
+	tz := now.Format("Z07:00")
+	c, err := d.ToUTC(timezone)
+	if err != nil {
+		t.Error(err)
+	}
+	if c == nil {
+		t.Error("expected a non-nil date")
+		return
 	}
 	expected := "2017-01-31T15:26:59Z"
 	if tz == "-04:00" {
@@ -469,4 +2118,346 @@ func TestDateWithoutTime(t *testing.T) {
 		t.Errorf("expected no error for date without time, got %v", err)
 	}
 }
+
+func TestDateIn(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Date{Year: "2017", Month: "07", Day: "04", Hour: "10", Minute: "26", Second: "59"}
+	actual, err := d.in(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "2017-07-04T14:26:59Z"
+	if actual.UTC().Format(time.RFC3339) != expected {
+		t.Errorf("expected %v, got %v", expected, actual.UTC().Format(time.RFC3339))
+	}
+	if _, err := (&Date{}).in(loc); err == nil {
+		t.Error("expected an error for an empty date, got no error")
+	}
+}
+
+func TestEncodeRecord(t *testing.T) {
+	v := struct {
+		ID      string {{tag "id" "string"}}
+		Name    string {{tag "name" "string"}}
+		Created Date   {{tag "created" "Date"}}
+		Updated Date   {{tag "updated" "Date"}}
+	}{
+		Name:    "Acme & Sons",
+		Created: Date{Year: "2017", Month: "01", Day: "31"},
+	}
+	actual, err := encodeRecord("Customer", &v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Customer><name>Acme &amp; Sons</name><created><Date><month>01</month><day>31</day><year>2017</year></Date></created></Customer>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !IsNotFound(statusError("Delete", "Task", "601")) {
+		t.Error("expected status 601 to be not found")
+	}
+	if IsNotFound(statusError("Delete", "Task", "416")) {
+		t.Error("expected status 416 not to be not found")
+	}
+	if !IsPermissionDenied(statusError("Delete", "Task", "416")) {
+		t.Error("expected status 416 to be permission denied")
+	}
+	if !IsDependentRecords(statusError("Delete", "Task", "1001")) {
+		t.Error("expected status 1001 to be dependent records")
+	}
+	if IsNotFound(nil) {
+		t.Error("expected nil not to be not found")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	q := NewQuery()
+	if q.method != "all" || q.example("Project") != "" {
+		t.Errorf("expected an empty query to match all records, got %+v", q)
+	}
+	q = NewQuery().Where("customerid", "123").Where("name", "A & B")
+	if q.err != nil {
+		t.Fatal(q.err)
+	}
+	if q.method != "equal to" {
+		t.Errorf("expected method equal to, got %v", q.method)
+	}
+	expected := "<Project><customerid>123</customerid><name>A &amp; B</name></Project>"
+	if actual := q.example("Project"); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if q = NewQuery().WhereNot("customerid", "123"); q.method != "not equal to" {
+		t.Errorf("expected method not equal to, got %v", q.method)
+	}
+	if q = NewQuery().Where("customerid", "123").WhereNot("userid", "1"); q.err == nil {
+		t.Error("expected an error when combining Where and WhereNot")
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	from := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2017, 3, 15, 0, 0, 0, 0, time.UTC)
+	q := NewQuery().Between("starts", from, to)
+	expected := " filter=\"newer-than,older-than\" field=\"starts,starts\""
+	if actual := q.filterAttributes(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	expected = "<Date><year>2017</year><month>3</month><day>1</day><hour>0</hour><minute>0</minute><second>0</second></Date><Date><year>2017</year><month>3</month><day>15</day><hour>0</hour><minute>0</minute><second>0</second></Date>"
+	if actual := q.filterBody(time.UTC); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = NewQuery().NewerThan("updated", time.Date(2017, 3, 1, 15, 4, 5, 0, time.UTC))
+	expected = "<Date><year>2017</year><month>3</month><day>1</day><hour>10</hour><minute>4</minute><second>5</second></Date>"
+	if actual := q.filterBody(loc); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual := NewQuery().filterAttributes(); actual != "" {
+		t.Errorf("expected no filter attributes, got %v", actual)
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	o := newListOptions(nil)
+	if o.pageSize != 1000 || o.concurrency != 1 {
+		t.Errorf("expected 1000 records per page and 1 page at a time, got %+v", o)
+	}
+	o = newListOptions([]ListOption{WithPageSize(250), WithConcurrency(4)})
+	if o.pageSize != 250 || o.concurrency != 4 {
+		t.Errorf("expected 250 records per page and 4 pages at a time, got %+v", o)
+	}
+	if passes := o.passes(); len(passes) != 1 || passes[0] {
+		t.Errorf("expected active records by default, got %v", passes)
+	}
+	if passes := newListOptions([]ListOption{WithRecords(DeletedRecords)}).passes(); len(passes) != 1 || !passes[0] {
+		t.Errorf("expected only deleted records, got %v", passes)
+	}
+	if passes := newListOptions([]ListOption{WithRecords(AllRecords)}).passes(); len(passes) != 2 || passes[0] || !passes[1] {
+		t.Errorf("expected active then deleted records, got %v", passes)
+	}
+	o = newListOptions([]ListOption{WithPageSize(0), WithConcurrency(-1)})
+	if o.pageSize != 1000 || o.concurrency != 1 {
+		t.Errorf("expected invalid options to use the defaults, got %+v", o)
+	}
+}
+
+func TestResultDecode(t *testing.T) {
+	r := Result{Type: "Customer", records: []byte("<Customer><id>1</id></Customer><Customer><id>2</id></Customer>")}
+	var records []struct {
+		ID string {{tag "id" "string"}}
+	}
+	if err := r.Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "1" || records[1].ID != "2" {
+		t.Errorf("unexpected records %+v", records)
+	}
+	if err := r.Decode(records); err == nil {
+		t.Error("expected an error when not decoding into a pointer to a slice")
+	}
+}
+
+func TestEncodeRecordFields(t *testing.T) {
+	v := struct {
+		ID    string {{tag "id" "string"}}
+		Name  string {{tag "name" "string"}}
+		Notes string {{tag "notes" "string"}}
+	}{
+		ID:   "42",
+		Name: "Acme",
+	}
+	actual, err := encodeRecord("Customer", &v, map[Field]bool{"id": true, "notes": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Customer><id>42</id><notes></notes></Customer>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestTime(t *testing.T) {
+	var v struct {
+		Updated Time {{tag "updated" "Time"}}
+		Created Time {{tag "created" "Time"}}
+	}
+	data := "<Project><updated><Date><hour>11</hour><minute>30</minute><timezone></timezone><second>12</second><month>03</month><day>01</day><year>2017</year></Date></updated><created><Date><year></year></Date></created></Project>"
+	if err := xml.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2017-03-01T11:30:12Z"
+	if v.Updated.Format(time.RFC3339) != expected {
+		t.Errorf("expected %v, got %v", expected, v.Updated.Format(time.RFC3339))
+	}
+	if !v.Created.IsZero() {
+		t.Errorf("expected an empty date to decode as zero, got %v", v.Created)
+	}
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := v.Updated.in(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.UTC().Format(time.RFC3339) != "2017-03-01T16:30:12Z" {
+		t.Errorf("expected 2017-03-01T16:30:12Z, got %v", actual.UTC().Format(time.RFC3339))
+	}
+}
+
+func TestEncodeRecordCustom(t *testing.T) {
+	type custom struct {
+		Region string {{tag "region__c" "string"}}
+	}
+	v := struct {
+		custom
+		ID string {{tag "id" "string"}}
+	}{
+		custom: custom{Region: "West"},
+		ID:     "42",
+	}
+	actual, err := encodeRecord("Customer", &v, map[Field]bool{"id": true, "region__c": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Customer><region__c>West</region__c><id>42</id></Customer>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestEncodeRecordTyped(t *testing.T) {
+	v := struct {
+		ID      int64   {{tag "id" "int64"}}
+		Active  bool    {{tag "active" "bool"}}
+		Budget  float64 {{tag "budget" "float64"}}
+		Updated Time    {{tag "updated" "Time"}}
+	}{
+		ID:      42,
+		Active:  true,
+		Budget:  1000000,
+		Updated: Time{time.Date(2017, 3, 1, 11, 30, 12, 0, time.UTC)},
+	}
+	actual, err := encodeRecord("Project", &v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Project><id>42</id><active>1</active><budget>1000000</budget><updated><Date><hour>11</hour><minute>30</minute><second>12</second><month>3</month><day>1</day><year>2017</year></Date></updated></Project>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestEncodeRecordZero(t *testing.T) {
+	v := struct {
+		Name   string  {{tag "name" "string"}}
+		Active bool    {{tag "active" "bool"}}
+		Budget float64 {{tag "budget" "float64"}}
+		Notes  string  {{tag "notes" "string"}}
+	}{
+		Name: "Anvil",
+	}
+	actual, err := encodeRecord("Project", &v, nil, "active", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Project><name>Anvil</name><active>0</active><budget>0</budget></Project>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestEncodeRecordNested(t *testing.T) {
+	type flag struct {
+		Name    string {{tag "name" "string"}}
+		Setting bool   {{tag "setting" "bool"}}
+	}
+	type attachment struct {
+		Name string {{tag "name" "string"}}
+		Date Time   {{tag "date" "Time"}}
+	}
+	v := struct {
+		ID         string     {{tag "id" "string"}}
+		Flags      []flag     {{tag "flags>Flag" "[]Flag"}}
+		Attachment attachment {{tag "attachment" "Attachment"}}
+	}{
+		ID:         "42",
+		Flags:      []flag{{"{{"}}Name: "billable", Setting: true}, {Name: "closed"}},
+		Attachment: attachment{Name: "a.pdf", Date: Time{time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)}},
+	}
+	actual, err := encodeRecord("Ticket", &v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Ticket><id>42</id><flags><Flag><name>billable</name><setting>1</setting></Flag><Flag><name>closed</name></Flag></flags><attachment><name>a.pdf</name><date><Date><hour>0</hour><minute>0</minute><second>0</second><month>1</month><day>2</day><year>2017</year></Date></date></attachment></Ticket>"
+	if expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	var decoded struct {
+		Flags []flag {{tag "flags>Flag" "[]Flag"}}
+	}
+	if err := xml.Unmarshal([]byte(actual), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Flags) != 2 || decoded.Flags[1].Name != "closed" {
+		t.Errorf("unexpected flags %+v", decoded.Flags)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	if err := statusError("Add", "Customer", "0"); err != nil {
+		t.Errorf("expected no error for status 0, got %v", err)
+	}
+	err := statusError("Add", "Customer", "601")
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Code != 601 || e.Command != "Add" || e.Type != "Customer" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	expected := "openair: Add Customer failed with status 601: no record with the given id"
+	if e.Error() != expected {
+		t.Errorf("expected %v, got %v", expected, e.Error())
+	}
+	expected = "openair: Auth failed with status 12345"
+	if actual := statusError("Auth", "", "12345").Error(); actual != expected {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	if !IsAuthError(statusError("Auth", "", "12345")) {
+		t.Error("expected an Auth status to be an auth error")
+	}
+	if !IsAuthError(statusError("Read", "Customer", "401")) {
+		t.Error("expected status 401 to be an auth error")
+	}
+	if IsAuthError(statusError("Read", "Customer", "601")) {
+		t.Error("expected status 601 not to be an auth error")
+	}
+	if IsAuthError(errors.New("unauthorized")) {
+		t.Error("expected a plain error not to be an auth error")
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	if !IsRateLimited(statusError("Read", "Customer", "556")) {
+		t.Error("expected status 556 to be rate limited")
+	}
+	if IsRateLimited(statusError("Read", "Customer", "601")) {
+		t.Error("expected status 601 not to be rate limited")
+	}
+}
 `))
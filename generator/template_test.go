@@ -1,6 +1,12 @@
 package generator
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -59,12 +65,18 @@ var _ = Describe("Template", func() {
 		It("handles date types", func() {
 			Ω(tag("fieldname", "Date")).Should(BeEquivalentTo("`xml:\"fieldname>Date,omitempty\" json:\"fieldname,omitempty\"`"))
 			Ω(tag("fieldname", "date")).Should(BeEquivalentTo("`xml:\"fieldname>Date,omitempty\" json:\"fieldname,omitempty\"`"))
+			Ω(tag("fieldname", "Time")).Should(BeEquivalentTo("`xml:\"fieldname>Date,omitempty\" json:\"fieldname,omitempty\"`"))
 		})
 
 		It("handles address types", func() {
 			Ω(tag("fieldname", "Address")).Should(BeEquivalentTo("`xml:\"fieldname>Address,omitempty\" json:\"fieldname,omitempty\"`"))
 			Ω(tag("fieldname", "address")).Should(BeEquivalentTo("`xml:\"fieldname>Address,omitempty\" json:\"fieldname,omitempty\"`"))
 		})
+
+		It("handles nested paths", func() {
+			Ω(tag("flags>Flag", "[]Flag")).Should(BeEquivalentTo("`xml:\"flags>Flag,omitempty\" json:\"flags,omitempty\"`"))
+			Ω(tag("contact", "Contact")).Should(BeEquivalentTo("`xml:\"contact,omitempty\" json:\"contact,omitempty\"`"))
+		})
 	})
 
 	Describe("xmltag()", func() {
@@ -101,4 +113,39 @@ var _ = Describe("Template", func() {
 			Ω(backtick()).Should(BeEquivalentTo("`"))
 		})
 	})
+
+	Describe("generated code", func() {
+		// The methods shared by every datatype are tested once, against
+		// Customer, by the tests in testdata/runtime
+		It("passes the runtime tests", func() {
+			gobin, err := exec.LookPath("go")
+			if err != nil {
+				Skip("go is not installed")
+			}
+			body, err := ioutil.ReadFile(SchemaPath("testdata/schema", "Customer"))
+			Ω(err).ShouldNot(HaveOccurred())
+			files, err := Generate(context.Background(), "openair", map[string][]byte{"Customer": body}, Options{})
+			Ω(err).ShouldNot(HaveOccurred())
+			tests, err := filepath.Glob("testdata/runtime/*_test.go")
+			Ω(err).ShouldNot(HaveOccurred())
+			for _, name := range tests {
+				src, err := ioutil.ReadFile(name)
+				Ω(err).ShouldNot(HaveOccurred())
+				files[filepath.Base(name)] = src
+			}
+
+			// Inside the module, so the generated imports resolve
+			dir, err := ioutil.TempDir("testdata", "generated")
+			Ω(err).ShouldNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			for name, src := range files {
+				Ω(ioutil.WriteFile(filepath.Join(dir, name), src, 0644)).Should(Succeed())
+			}
+
+			cmd := exec.Command(gobin, "test", ".")
+			cmd.Dir = dir
+			output, err := cmd.CombinedOutput()
+			Ω(err).ShouldNot(HaveOccurred(), string(output))
+		})
+	})
 })
@@ -0,0 +1,25 @@
+package openair
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddCommand(t *testing.T) {
+	api := NewWithConfig(&Config{})
+	var v Customer
+	command, err := api.Customer.AddCommand(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(command.xml, "<deleted>") {
+		t.Errorf("expected zero fields to be left out, got %v", command.xml)
+	}
+	command, err = api.Customer.AddCommand(&v, CustomerFields.Deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(command.xml, "<deleted>") {
+		t.Errorf("expected the listed zero field to be sent, got %v", command.xml)
+	}
+}
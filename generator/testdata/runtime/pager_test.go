@@ -0,0 +1,165 @@
+package openair
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readLimit returns the offset and limit of the Read in request
+func readLimit(request string) (int, int) {
+	var offset, limit int
+	if i := strings.Index(request, `limit="`); i >= 0 {
+		fmt.Sscanf(request[i:], `limit="%d,%d"`, &offset, &limit)
+	}
+	return offset, limit
+}
+
+// customerPages responds to each Read with the page its offset and limit
+// select from total customers, whose ids count from 1, or from endless
+// customers when total is negative. A page fails with status 602 when fail
+// returns true for its offset.
+func customerPages(total int, fail func(offset int) bool) roundTripperFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		offset, limit := readLimit(string(b))
+		status := "0"
+		if fail != nil && fail(offset) {
+			status = "602"
+		}
+		var body bytes.Buffer
+		fmt.Fprintf(&body, `<response><Auth status="0"/><Read status="%s">`, status)
+		for id := offset + 1; status == "0" && id <= offset+limit && (total < 0 || id <= total); id++ {
+			fmt.Fprintf(&body, "<Customer><id>%d</id></Customer>", id)
+		}
+		body.WriteString("</Read></response>")
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(&body)}, nil
+	}
+}
+
+func TestPagerConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var offsets []int
+	pages := customerPages(9, nil)
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		offset, _ := readLimit(string(b))
+		mu.Lock()
+		offsets = append(offsets, offset)
+		mu.Unlock()
+		// Later pages respond first, so they are read out of order
+		time.Sleep(time.Duration(10-offset) * time.Millisecond)
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		return pages(req)
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(rt))
+
+	p := api.Customer.Pager(nil, WithPageSize(2), WithConcurrency(3))
+	var ids []string
+	for p.Next(context.Background()) {
+		for _, record := range p.Page() {
+			ids = append(ids, record.ID)
+		}
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[1 2 3 4 5 6 7 8 9]"; fmt.Sprint(ids) != expected {
+		t.Errorf("expected the records in offset order %v, got %v", expected, ids)
+	}
+	if p.Next(context.Background()) {
+		t.Error("expected the pass to end after the short last page")
+	}
+	if len(offsets) != 6 {
+		t.Errorf("expected 2 rounds of 3 pages, got reads at offsets %v", offsets)
+	}
+}
+
+func TestListAsyncCancel(t *testing.T) {
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(customerPages(-1, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	pages, errs := api.Customer.ListAsync(ctx, nil, WithPageSize(1))
+	if page := <-pages; len(page) != 1 {
+		t.Errorf("expected a page of 1 record, got %d", len(page))
+	}
+	// Stop reading, leaving the goroutine blocked on the next page
+	cancel()
+
+	timeout := time.After(5 * time.Second)
+	for pages != nil || errs != nil {
+		select {
+		case _, ok := <-pages:
+			if !ok {
+				pages = nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+			} else if err != context.Canceled {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+		case <-timeout:
+			t.Fatal("expected both channels to close after ctx was canceled")
+		}
+	}
+}
+
+func TestListAsyncError(t *testing.T) {
+	fail := func(offset int) bool { return offset == 2 }
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(customerPages(-1, fail)))
+	pages, errs := api.Customer.ListAsync(context.Background(), nil, WithPageSize(1))
+	n := 0
+	for page := range pages {
+		if len(page) != 1 {
+			t.Errorf("expected pages of 1 record, got %d", len(page))
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("expected the 2 pages before the failed one, got %d", n)
+	}
+	if err := <-errs; !hasCode(err, StatusInvalidField) {
+		t.Errorf("expected the error of the failed page, got %v", err)
+	}
+	if err, ok := <-errs; ok {
+		t.Errorf("expected the error channel to close after the error, got %v", err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	requests := 0
+	pages := customerPages(-1, nil)
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return pages(req)
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(rt))
+	stop := errors.New("stop")
+	calls := 0
+	err := api.Customer.ForEach(context.Background(), nil, func(page []Customer) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	}, WithPageSize(1))
+	if err != stop {
+		t.Errorf("expected the error from fn, got %v", err)
+	}
+	if calls != 2 || requests != 2 {
+		t.Errorf("expected to stop after the second page, got %d calls and %d requests", calls, requests)
+	}
+}
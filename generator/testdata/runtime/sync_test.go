@@ -0,0 +1,54 @@
+package openair
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	var requests []string
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, string(b))
+		body := `<response><Auth status="0"/><Read status="0"></Read></response>`
+		if strings.Contains(string(b), `include_nondeleted="1"`) {
+			body = `<response><Auth status="0"/><Read status="0">` +
+				`<Customer><updated><Date><year>2017</year><month>03</month><day>01</day><hour>11</hour><minute>30</minute><second>12</second></Date></updated></Customer>` +
+				`<Customer><updated><Date><year>2017</year><month>02</month><day>20</day><hour>09</hour><minute>00</minute><second>00</second></Date></updated></Customer>` +
+				`</Read></response>`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com"}, WithRoundTripper(rt))
+
+	since := time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)
+	records, mark, err := api.Customer.Sync(context.Background(), since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 records, got %d", len(records))
+	}
+	if expected := time.Date(2017, 3, 1, 11, 30, 12, 0, time.UTC); !mark.Equal(expected) {
+		t.Errorf("expected the mark to be the latest Updated time %v, got %v", expected, mark)
+	}
+	overlap := "<Date><year>2017</year><month>1</month><day>31</day><hour>23</hour><minute>59</minute><second>59</second></Date>"
+	if len(requests) == 0 || !strings.Contains(requests[0], overlap) {
+		t.Errorf("expected the read to start a second before since, got %v", requests)
+	}
+
+	_, next, err := api.Customer.Sync(context.Background(), mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.Equal(mark) {
+		t.Errorf("expected reading the same records again to keep the mark %v, got %v", mark, next)
+	}
+}
@@ -0,0 +1,59 @@
+package openair
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	var requests []string
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, string(b))
+		body := `<response><Auth status="0"/><Read status="0">` +
+			`<Customer><id>7</id><updated><Date><year>2017</year><month>03</month><day>01</day><hour>11</hour><minute>30</minute><second>12</second></Date></updated></Customer>` +
+			`<Customer><id>8</id></Customer>` +
+			`</Read></response>`
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api := NewWithConfig(&Config{Scheme: "https", Domain: "example.com", Timezone: "America/New_York"}, WithRoundTripper(rt))
+
+	tombstones, err := api.Customer.Tombstones(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Tombstone{
+		{Type: "Customer", ID: "7", Deleted: time.Date(2017, 3, 1, 16, 30, 12, 0, time.UTC)},
+		{Type: "Customer", ID: "8"},
+	}
+	if len(tombstones) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tombstones)
+	}
+	for i := range expected {
+		if tombstones[i].Type != expected[i].Type || tombstones[i].ID != expected[i].ID || !tombstones[i].Deleted.Equal(expected[i].Deleted) {
+			t.Errorf("expected %v, got %v", expected[i], tombstones[i])
+		}
+	}
+	if len(requests) != 1 || !strings.Contains(requests[0], `include_nondeleted="0" deleted="1"`) {
+		t.Errorf("expected one read of deleted records, got %v", requests)
+	}
+
+	stop := errors.New("stop")
+	err = api.Customer.ForEachTombstone(context.Background(), nil, func(page []Tombstone) error {
+		if len(page) != 2 || page[0].ID != "7" {
+			t.Errorf("expected a page of both tombstones, got %v", page)
+		}
+		return stop
+	})
+	if err != stop {
+		t.Errorf("expected the error from fn, got %v", err)
+	}
+}
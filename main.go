@@ -1,42 +1,159 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"text/tabwriter"
 
 	"github.com/joefitzgerald/openair/generator"
 	"github.com/kelseyhightower/envconfig"
 )
 
 var (
-	objectNames  = flag.String("object", "", "comma-separated list of OpenAir XML Datatype names; must be set")
+	objectNames  = flag.String("object", "", "comma-separated list of OpenAir XML Datatype names, or all for every datatype that can be read; must be set")
 	outputPrefix = flag.String("prefix", "", "prefix to be added to the output file")
 	outputSuffix = flag.String("suffix", "_openair", "suffix to be added to the output file")
+	schemaDir    = flag.String("schema-dir", "", "directory of captured <Datatype>.xml responses to generate from instead of OpenAir")
+	capture      = flag.Bool("capture", false, "fetch each datatype from OpenAir and save it to -schema-dir before generating")
+	overrides    = flag.String("overrides", "", "JSON file mapping Datatype.field to a Go type, Go field name, struct tags or skip")
+	customFields = flag.Bool("custom-fields", false, "type custom fields using their CustomField definitions")
+	typed        = flag.Bool("typed", false, "infer int64, float64, bool and time fields from the sample records instead of using strings")
+	samples      = flag.Int("samples", 10, "number of records of each datatype to read from OpenAir to infer its fields")
+	concurrency  = flag.Int("concurrency", 4, "number of datatypes to read from OpenAir at once")
+	dryRun       = flag.Bool("dry-run", false, "print which files and fields would change instead of writing the files")
+	diff         = flag.Bool("diff", false, "print a unified diff of the changes instead of writing the files")
+	check        = flag.Bool("check", false, "exit with status 1 if the generated files are out of date, instead of writing them")
 )
 
 func main() {
 	flag.Parse()
-	if len(*objectNames) == 0 {
+	listTypes := flag.Arg(0) == "list-types"
+	if listTypes {
+		// Flags can also follow the subcommand
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if len(*objectNames) == 0 && !listTypes {
 		log.Fatalf("the flag -object must be set")
 	}
 
 	// Only one directory at a time can be processed, and the default is ".".
 	dir := "."
-	if args := flag.Args(); len(args) == 1 {
+	if listTypes {
+		if flag.NArg() > 0 {
+			log.Fatalf("list-types takes no arguments")
+		}
+	} else if args := flag.Args(); len(args) == 1 {
 		dir = args[0]
 	} else if len(args) > 1 {
 		log.Fatalf("only one directory at a time")
 	}
+	if *capture && len(*schemaDir) == 0 {
+		log.Fatalf("the flag -schema-dir must be set when using -capture")
+	}
 
+	// Credentials are only needed when talking to OpenAir
 	var c generator.Config
-	err := envconfig.Process("openair", &c)
+	if len(*schemaDir) == 0 || *capture {
+		err := envconfig.Process("openair", &c)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if listTypes {
+		statuses, err := generator.ListTypes(ctx, c, generator.Options{SchemaDir: *schemaDir, Capture: *capture, Concurrency: *concurrency, Samples: *samples})
+		if err != nil {
+			log.Fatal(err)
+		}
+		printTypes(statuses)
+		return
+	}
+
+	var o map[string]generator.Override
+	if len(*overrides) > 0 {
+		var err error
+		o, err = generator.LoadOverrides(*overrides)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	g, err := generator.New(c, generator.Options{
+		ObjectNames:  *objectNames,
+		Dir:          dir,
+		OutputPrefix: *outputPrefix,
+		OutputSuffix: *outputSuffix,
+		SchemaDir:    *schemaDir,
+		Capture:      *capture,
+		Typed:        *typed,
+		Overrides:    o,
+		CustomFields: *customFields,
+		Concurrency:  *concurrency,
+		Samples:      *samples,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	g := generator.New(c, *objectNames, dir, *outputPrefix, *outputSuffix)
+	if *dryRun || *diff || *check {
+		changes, err := g.Changes(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Keep the diff apart from the summary, so it can be applied
+		summary := os.Stdout
+		if *diff {
+			summary = os.Stderr
+			for _, c := range changes {
+				fmt.Print(c.Diff)
+			}
+		}
+		printChanges(summary, changes)
+		if *check && len(changes) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := g.GenerateFiles(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printTypes writes each datatype in the catalog and whether it can be read
+func printTypes(statuses []generator.TypeStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, s := range statuses {
+		if s.Err != nil {
+			fmt.Fprintf(w, "%s\tnot readable: %v\n", s.Datatype, s.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\treadable\n", s.Datatype)
+	}
+	w.Flush()
+}
 
-	g.GenerateCommonFile()
-	g.GenerateCommonTestFile()
-	g.GenerateModelFiles()
+// printChanges writes a summary of the files and fields that would change
+func printChanges(w io.Writer, changes []generator.FileChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "generated files are up to date")
+		return
+	}
+	for _, c := range changes {
+		if c.New {
+			fmt.Fprintf(w, "%s: new file\n", c.Name)
+		} else if c.Removed {
+			fmt.Fprintf(w, "%s: removed, no longer generated\n", c.Name)
+		} else {
+			fmt.Fprintf(w, "%s: changed\n", c.Name)
+		}
+		for _, f := range c.Fields {
+			fmt.Fprintf(w, "  %s\n", f)
+		}
+	}
 }